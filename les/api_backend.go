@@ -0,0 +1,84 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"context"
+
+	"github.com/athereum/go-athereum/ath/gasprice"
+	"github.com/athereum/go-athereum/common"
+	"github.com/athereum/go-athereum/core/types"
+)
+
+// LesApiBackend implements athapi.Backend on top of a LightAtlantis node,
+// serving RPC requests by issuing ODR retrievals against connected LES
+// servers rather than reading from a local, fully synced database.
+type LesApiBackend struct {
+	lath *LightAtlantis
+	gpo  *gasprice.Oracle
+}
+
+// GetTransactionStatus looks up the status (unknown, pending, queued or
+// included) of a transaction previously submitted via SendTx, without
+// requiring a full receipts round-trip to confirm inclusion.
+func (b *LesApiBackend) GetTransactionStatus(ctx context.Context, txHash common.Hash) (*TxStatus, error) {
+	if tx := b.lath.txPool.GetTransaction(txHash); tx != nil {
+		return &TxStatus{Status: TxStatusPending}, nil
+	}
+	req := &TxStatusRequest{Hashes: []common.Hash{txHash}}
+	if err := b.lath.odr.Retrieve(ctx, req); err != nil {
+		return nil, err
+	}
+	if len(req.Status) == 0 {
+		return &TxStatus{Status: TxStatusUnknown}, nil
+	}
+	return &req.Status[0], nil
+}
+
+// SyncCheckpoint fetches a peer's locally adopted checkpoint for sectionIdx
+// and verifies it against the configured oracle signers, for clients that
+// would rather ask a connected peer directly than depend on the on-chain
+// checkpoint registrar contract being reachable.
+func (b *LesApiBackend) SyncCheckpoint(ctx context.Context, sectionIdx uint64) (*TrustedCheckpoint, [][]byte, error) {
+	req := &CheckpointRequest{SectionIdx: sectionIdx}
+	if err := b.lath.odr.Retrieve(ctx, req); err != nil {
+		return nil, nil, err
+	}
+	oracle := NewCheckpointOracle(b.lath.config.CheckpointOracleSigners, b.lath.config.CheckpointOracleThreshold)
+	if err := oracle.Verify(req.Data.Checkpoint.Hash(), req.Data.Signatures); err != nil {
+		return nil, nil, err
+	}
+	return &req.Data.Checkpoint, req.Data.Signatures, nil
+}
+
+// TransactionByHash retrieves a transaction by hash from the local tx pool.
+// For a transaction not in the pool it falls back to an ODR lookup of its
+// inclusion status and returns only the block hash and indices from that
+// status; the returned transaction is then nil.
+func (b *LesApiBackend) TransactionByHash(ctx context.Context, txHash common.Hash) (*types.Transaction, common.Hash, uint64, uint64, error) {
+	if tx := b.lath.txPool.GetTransaction(txHash); tx != nil {
+		return tx, common.Hash{}, 0, 0, nil
+	}
+	status, err := b.GetTransactionStatus(ctx, txHash)
+	if err != nil {
+		return nil, common.Hash{}, 0, 0, err
+	}
+	if status.Status != TxStatusIncluded || status.Lookup == nil {
+		return nil, common.Hash{}, 0, 0, nil
+	}
+	return nil, status.Lookup.BlockHash, status.Lookup.BlockIndex, status.Lookup.Index, nil
+}
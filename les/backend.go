@@ -18,6 +18,7 @@
 package les
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -47,26 +48,22 @@ import (
 )
 
 type LightAtlantis struct {
+	lesCommons
+
 	config *ath.Config
 
-	odr         *LesOdr
-	relay       *LesTxRelay
-	chainConfig *params.ChainConfig
+	odr   *LesOdr
+	relay *LesTxRelay
 	// Channel for shutting down the service
 	shutdownChan chan bool
 	// Handlers
-	peers           *peerSet
-	txPool          *light.TxPool
-	blockchain      *light.LightChain
-	protocolManager *ProtocolManager
-	serverPool      *serverPool
-	reqDist         *requestDistributor
-	retriever       *retrieveManager
-	// DB interfaces
-	chainDb athdb.Database // Block chain database
-
-	bloomRequests                              chan chan *bloombits.Retrieval // Channel receiving bloom data retrieval requests
-	bloomIndexer, chtIndexer, bloomTrieIndexer *core.ChainIndexer
+	txPool     *light.TxPool
+	blockchain *light.LightChain
+	serverPool *serverPool
+	reqDist    *requestDistributor
+	retriever  *retrieveManager
+
+	bloomRequests chan chan *bloombits.Retrieval // Channel receiving bloom data retrieval requests
 
 	ApiBackend *LesApiBackend
 
@@ -95,20 +92,22 @@ func New(ctx *node.ServiceContext, config *ath.Config) (*LightAtlantis, error) {
 	quitSync := make(chan struct{})
 
 	lath := &LightAtlantis{
-		config:           config,
-		chainConfig:      chainConfig,
-		chainDb:          chainDb,
-		eventMux:         ctx.EventMux,
-		peers:            peers,
-		reqDist:          newRequestDistributor(peers, quitSync),
-		accountManager:   ctx.AccountManager,
-		engine:           ath.CreateConsensusEngine(ctx, &config.Ethash, chainConfig, chainDb),
-		shutdownChan:     make(chan bool),
-		networkId:        config.NetworkId,
-		bloomRequests:    make(chan chan *bloombits.Retrieval),
-		bloomIndexer:     ath.NewBloomIndexer(chainDb, light.BloomTrieFrequency),
-		chtIndexer:       light.NewChtIndexer(chainDb, true),
-		bloomTrieIndexer: light.NewBloomTrieIndexer(chainDb, true),
+		lesCommons: lesCommons{
+			chainDb:          chainDb,
+			chainConfig:      chainConfig,
+			peers:            peers,
+			bloomIndexer:     ath.NewBloomIndexer(chainDb, light.BloomTrieFrequency),
+			chtIndexer:       light.NewChtIndexer(chainDb, true),
+			bloomTrieIndexer: light.NewBloomTrieIndexer(chainDb, true),
+		},
+		config:         config,
+		eventMux:       ctx.EventMux,
+		reqDist:        newRequestDistributor(peers, quitSync),
+		accountManager: ctx.AccountManager,
+		engine:         ath.CreateConsensusEngine(ctx, &config.Ethash, chainConfig, chainDb),
+		shutdownChan:   make(chan bool),
+		networkId:      config.NetworkId,
+		bloomRequests:  make(chan chan *bloombits.Retrieval),
 	}
 
 	lath.relay = NewLesTxRelay(peers, lath.reqDist)
@@ -125,20 +124,100 @@ func New(ctx *node.ServiceContext, config *ath.Config) (*LightAtlantis, error) {
 		lath.blockchain.SetHead(compat.RewindTo)
 		rawdb.WriteChainConfig(chainDb, genesisHash, chainConfig)
 	}
-
 	lath.txPool = light.NewTxPool(lath.chainConfig, lath.blockchain, lath.relay)
-	if lath.protocolManager, err = NewProtocolManager(lath.chainConfig, true, ClientProtocolVersions, config.NetworkId, lath.eventMux, lath.engine, lath.peers, lath.blockchain, nil, chainDb, lath.odr, lath.relay, lath.serverPool, quitSync, &lath.wg); err != nil {
+	if lath.protocolManager, err = NewProtocolManager(lath.chainConfig, true, config.NetworkId, lath.eventMux, lath.engine, lath.peers, lath.blockchain, nil, chainDb, lath.odr, lath.relay, lath.serverPool, quitSync, &lath.wg); err != nil {
 		return nil, err
 	}
+	lath.protocolManager.SubProtocols = lath.makeProtocols(ClientProtocolVersions)
+	lath.protocolManager.SetUltraLight(config.UltraLightServers, config.UltraLightFraction)
+	lath.protocolManager.SetUltraLightSigning(config.UltraLightServerKey)
 	lath.ApiBackend = &LesApiBackend{lath, nil}
 	gpoParams := config.GPO
 	if gpoParams.Default == nil {
 		gpoParams.Default = config.GasPrice
 	}
 	lath.ApiBackend.gpo = gasprice.NewOracle(lath.ApiBackend, gpoParams)
+
+	// If a trusted checkpoint was configured and verifies against the
+	// configured oracle signers, seed the CHT/BloomTrie indexers with its
+	// roots and skip header verification for the signed range, instead of
+	// always starting the sync from genesis.
+	if config.Checkpoint != nil {
+		checkpoint := TrustedCheckpoint{
+			SectionIndex:  config.Checkpoint.SectionIndex,
+			CHTRoot:       config.Checkpoint.CHTRoot,
+			BloomTrieRoot: config.Checkpoint.BloomTrieRoot,
+			SectionHead:   config.Checkpoint.SectionHead,
+		}
+		oracle := NewCheckpointOracle(config.CheckpointOracleSigners, config.CheckpointOracleThreshold)
+		if err := oracle.Verify(checkpoint.Hash(), config.Checkpoint.Signatures); err != nil {
+			log.Warn("Ignoring untrusted checkpoint", "section", checkpoint.SectionIndex, "err", err)
+		} else {
+			lath.adoptCheckpoint(checkpoint, config.Checkpoint.Signatures)
+		}
+	}
+	// Subscribe to peer registration so a client with no statically
+	// configured checkpoint, but with oracle signers configured, can still
+	// fall back to asking a connected peer directly instead of requiring the
+	// on-chain checkpoint registrar contract to be reachable.
+	lath.peers.subscribe(lath)
+
 	return lath, nil
 }
 
+// adoptCheckpoint seeds the CHT/BloomTrie indexers and rewinds local chain
+// state to cp, and makes cp available for this node to relay to peers that
+// ask for it directly via GetCheckpointDataMsg. It is idempotent: if a
+// checkpoint has already been adopted (racing peer-registration goroutines
+// can both reach here), later calls are no-ops rather than re-adopting and
+// rewinding the chain a second time.
+func (s *LightAtlantis) adoptCheckpoint(cp TrustedCheckpoint, sigs [][]byte) {
+	s.checkpointLock.Lock()
+	defer s.checkpointLock.Unlock()
+	if s.checkpoint != nil {
+		return
+	}
+	log.Info("Adopting trusted checkpoint", "section", cp.SectionIndex, "head", cp.SectionHead)
+	s.checkpoint = &cp
+	s.chtIndexer.AddCheckpoint(cp.SectionIndex, cp.CHTRoot)
+	s.bloomTrieIndexer.AddCheckpoint(cp.SectionIndex, cp.BloomTrieRoot)
+	s.blockchain.SetHead(cp.SectionHead)
+	s.protocolManager.SetCheckpoint(&cp, sigs)
+}
+
+// hasCheckpoint reports whether a checkpoint has been adopted, guarding the
+// read with checkpointLock the same way adoptCheckpoint guards the write.
+func (s *LightAtlantis) hasCheckpoint() bool {
+	s.checkpointLock.Lock()
+	defer s.checkpointLock.Unlock()
+	return s.checkpoint != nil
+}
+
+// registerPeer implements peerSetNotify. If this client hasn't adopted a
+// checkpoint yet but trusts a set of oracle signers, it asks for the genesis
+// section's checkpoint over the ODR, which sends the request to whichever
+// connected LES/2 peer the request distributor picks, as a peer-relay
+// fallback for nodes that can't yet reach the on-chain registrar contract.
+// Several peers can connect around the same time and each spawn one of these
+// goroutines; adoptCheckpoint itself is what actually prevents more than one
+// of them from adopting, so the check here is only an early-exit.
+func (s *LightAtlantis) registerPeer(p *peer) {
+	if s.hasCheckpoint() || len(s.config.CheckpointOracleSigners) == 0 {
+		return
+	}
+	go func() {
+		cp, sigs, err := s.ApiBackend.SyncCheckpoint(context.Background(), 0)
+		if err != nil {
+			log.Debug("Failed to sync checkpoint from peer", "peer", p.id, "err", err)
+			return
+		}
+		s.adoptCheckpoint(*cp, sigs)
+	}()
+}
+
+// unregisterPeer implements peerSetNotify.
+func (s *LightAtlantis) unregisterPeer(p *peer) {}
+
 func lesTopic(genesisHash common.Hash, protocolVersion uint) discv5.Topic {
 	var name string
 	switch protocolVersion {
@@ -198,6 +277,21 @@ func (s *LightAtlantis) APIs() []rpc.API {
 			Version:   "1.0",
 			Service:   s.netRPCService,
 			Public:    true,
+		}, {
+			Namespace: "les",
+			Version:   "1.0",
+			Service:   NewUltraLightAPI(s.protocolManager.ulc),
+			Public:    false,
+		}, {
+			Namespace: "les",
+			Version:   "1.0",
+			Service:   NewCheckpointOracleAPI(s),
+			Public:    false,
+		}, {
+			Namespace: "debug",
+			Version:   "1.0",
+			Service:   NewFlowControlAPI(s.peers),
+			Public:    false,
 		},
 	}...)
 }
@@ -216,7 +310,14 @@ func (s *LightAtlantis) EventMux() *event.TypeMux           { return s.eventMux
 // Protocols implements node.Service, returning all the currently configured
 // network protocols to start.
 func (s *LightAtlantis) Protocols() []p2p.Protocol {
-	return s.protocolManager.SubProtocols
+	return s.makeProtocols(ClientProtocolVersions)
+}
+
+// NodeInfo retrieves some protocol metadata about the running host node,
+// shared with a future LES server through lesCommons.nodeInfo.
+func (s *LightAtlantis) NodeInfo() interface{} {
+	head := s.blockchain.CurrentHeader()
+	return s.nodeInfo(head.Hash(), s.blockchain.Genesis().Hash(), s.blockchain.GetTd(head.Hash(), head.Number.Uint64()))
 }
 
 // Start implements node.Service, starting all internal goroutines needed by the
@@ -0,0 +1,133 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"errors"
+
+	"github.com/athereum/go-athereum/common"
+	"github.com/athereum/go-athereum/crypto"
+	"github.com/athereum/go-athereum/rlp"
+)
+
+// TrustedCheckpoint represents a set of post-processed (CHT/BloomTrie)
+// trusted roots for a given section, allowing a light client to start
+// syncing from here instead of genesis once it has been verified against
+// the checkpoint oracle.
+type TrustedCheckpoint struct {
+	SectionIndex  uint64
+	CHTRoot       common.Hash
+	BloomTrieRoot common.Hash
+	SectionHead   uint64 // block number the section's CHT/BloomTrie roots cover up to
+}
+
+// Hash returns the hash that signers sign off on to vouch for this
+// checkpoint.
+func (c *TrustedCheckpoint) Hash() common.Hash {
+	enc, _ := rlp.EncodeToBytes(c)
+	return common.BytesToHash(crypto.Keccak256(enc))
+}
+
+// HashEqual reports whether the checkpoint roots and head match the given
+// candidate, ignoring the signatures that accompanied it.
+func (c *TrustedCheckpoint) HashEqual(d TrustedCheckpoint) bool {
+	return c.SectionIndex == d.SectionIndex && c.CHTRoot == d.CHTRoot &&
+		c.BloomTrieRoot == d.BloomTrieRoot && c.SectionHead == d.SectionHead
+}
+
+// CheckpointOracle verifies checkpoints signed by a configurable set of
+// trusted signers before a light client is allowed to seed its CHT/BloomTrie
+// indexers from them and skip syncing from genesis. It mirrors the
+// threshold-signature scheme introduced for on-chain registrar contracts,
+// but also accepts checkpoints fetched directly from a peer via
+// GetCheckpointDataMsg for clients that don't want to depend on the oracle
+// contract being reachable yet.
+type CheckpointOracle struct {
+	signers   []common.Address
+	threshold int
+}
+
+// NewCheckpointOracle creates a checkpoint oracle that accepts a checkpoint
+// once at least threshold of the given signers have signed off on it.
+func NewCheckpointOracle(signers []common.Address, threshold int) *CheckpointOracle {
+	return &CheckpointOracle{signers: signers, threshold: threshold}
+}
+
+// VerifySigners recovers the signer of each (hash, signature) pair and
+// reports whether at least the configured threshold of distinct, trusted
+// signers vouched for the checkpoint.
+func (co *CheckpointOracle) VerifySigners(hash common.Hash, signatures [][]byte) (bool, []common.Address) {
+	trusted := make(map[common.Address]bool)
+	for _, s := range co.signers {
+		trusted[s] = true
+	}
+	seen := make(map[common.Address]bool)
+	var signed []common.Address
+	for _, sig := range signatures {
+		pubkey, err := crypto.SigToPub(hash.Bytes(), sig)
+		if err != nil {
+			continue
+		}
+		addr := crypto.PubkeyToAddress(*pubkey)
+		if trusted[addr] && !seen[addr] {
+			seen[addr] = true
+			signed = append(signed, addr)
+		}
+	}
+	return len(signed) >= co.threshold, signed
+}
+
+// CheckpointData is the network representation of a TrustedCheckpoint along
+// with the signatures collected for it, as served by GetCheckpointDataMsg.
+type CheckpointData struct {
+	Checkpoint TrustedCheckpoint
+	Signatures [][]byte
+}
+
+// Verify checks that hash (a TrustedCheckpoint's Hash) was signed by at least the
+// oracle's threshold of trusted signers, returning errCheckpointNotTrusted
+// otherwise.
+func (co *CheckpointOracle) Verify(hash common.Hash, signatures [][]byte) error {
+	if ok, _ := co.VerifySigners(hash, signatures); !ok {
+		return errCheckpointNotTrusted
+	}
+	return nil
+}
+
+var errCheckpointNotTrusted = errors.New("checkpoint not signed by enough trusted signers")
+
+// CheckpointOracleAPI exposes the checkpoint currently adopted by a light
+// client via les_getCheckpoint, so operators can confirm which trusted
+// CHT/BloomTrie roots it started syncing from.
+type CheckpointOracleAPI struct {
+	lath *LightAtlantis
+}
+
+// NewCheckpointOracleAPI creates the RPC API exposing lath's adopted
+// checkpoint.
+func NewCheckpointOracleAPI(lath *LightAtlantis) *CheckpointOracleAPI {
+	return &CheckpointOracleAPI{lath: lath}
+}
+
+// GetCheckpoint returns the checkpoint the client has adopted, either from its
+// configuration at startup or later from a connected peer, or nil if it has
+// adopted none.
+func (api *CheckpointOracleAPI) GetCheckpoint() *TrustedCheckpoint {
+	api.lath.checkpointLock.Lock()
+	defer api.lath.checkpointLock.Unlock()
+	return api.lath.checkpoint
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"testing"
+
+	"github.com/athereum/go-athereum/common"
+	"github.com/athereum/go-athereum/crypto"
+)
+
+// TestCheckpointOracleVerify checks that a checkpoint is only accepted once
+// at least the configured threshold of distinct trusted signers have signed
+// its hash, and that signatures from outside the trusted set don't count.
+func TestCheckpointOracleVerify(t *testing.T) {
+	keyA, _ := crypto.GenerateKey()
+	keyB, _ := crypto.GenerateKey()
+	keyC, _ := crypto.GenerateKey() // not trusted
+
+	addrA := crypto.PubkeyToAddress(keyA.PublicKey)
+	addrB := crypto.PubkeyToAddress(keyB.PublicKey)
+
+	oracle := NewCheckpointOracle([]common.Address{addrA, addrB}, 2)
+	cp := TrustedCheckpoint{SectionIndex: 1, CHTRoot: common.HexToHash("0x1"), BloomTrieRoot: common.HexToHash("0x2"), SectionHead: 100}
+	hash := cp.Hash()
+
+	sigA, err := crypto.Sign(hash.Bytes(), keyA)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	sigC, err := crypto.Sign(hash.Bytes(), keyC)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+
+	if err := oracle.Verify(hash, [][]byte{sigA}); err == nil {
+		t.Fatalf("checkpoint accepted with only one of two required signatures")
+	}
+	if err := oracle.Verify(hash, [][]byte{sigA, sigC}); err == nil {
+		t.Fatalf("checkpoint accepted with a signature from an untrusted signer counted towards the threshold")
+	}
+	if err := oracle.Verify(hash, [][]byte{sigA, sigA}); err == nil {
+		t.Fatalf("checkpoint accepted with the same signer's signature counted twice")
+	}
+
+	sigB, err := crypto.Sign(hash.Bytes(), keyB)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if err := oracle.Verify(hash, [][]byte{sigA, sigB}); err != nil {
+		t.Fatalf("checkpoint rejected despite meeting the signer threshold: %v", err)
+	}
+}
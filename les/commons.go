@@ -0,0 +1,98 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"sync"
+
+	"github.com/athereum/go-athereum/athdb"
+	"github.com/athereum/go-athereum/core"
+	"github.com/athereum/go-athereum/p2p"
+	"github.com/athereum/go-athereum/params"
+)
+
+// lesCommons holds the fields and helpers shared by both the light client
+// (LightAtlantis) and a LES server, so that adding a server backend later
+// doesn't mean duplicating the chain database, indexers and peer set, or
+// reimplementing Protocols()/node info reporting a second time.
+type lesCommons struct {
+	chainDb                      athdb.Database
+	chainConfig                  *params.ChainConfig
+	peers                        *peerSet
+	protocolManager              *ProtocolManager
+	chtIndexer, bloomTrieIndexer *core.ChainIndexer
+	bloomIndexer                 *core.ChainIndexer
+
+	// checkpointLock guards checkpoint below, since multiple connecting
+	// peers can concurrently race to be the one that adopts it - see
+	// LightAtlantis.registerPeer.
+	checkpointLock sync.Mutex
+	// checkpoint is the trusted CHT/BloomTrie checkpoint, if any, that the
+	// indexers above were seeded from instead of starting at genesis.
+	checkpoint *TrustedCheckpoint
+}
+
+// makeProtocols builds the p2p.Protocol list for the given LES protocol
+// versions, wiring each version's Run function through makeBlockchainV2Handler
+// so that both the client and a server construct their ProtocolManager
+// differently but expose it to p2p.Server the same way.
+func (c *lesCommons) makeProtocols(versions []uint) []p2p.Protocol {
+	protos := make([]p2p.Protocol, len(versions))
+	for i, version := range versions {
+		version := version
+		protos[i] = p2p.Protocol{
+			Name:    "les",
+			Version: version,
+			Length:  ProtocolLengths[version],
+			Run:     c.makeBlockchainV2Handler(version),
+		}
+	}
+	return protos
+}
+
+// lesNodeInfo is the les sub-protocol metadata known about the host peer
+// advertised in the devp2p node record.
+type lesNodeInfo struct {
+	Network    uint64      `json:"network"`    // Atlantis network ID
+	Difficulty interface{} `json:"difficulty"` // Total difficulty of the host's blockchain
+	Genesis    interface{} `json:"genesis"`     // SHA3 hash of the host's genesis block
+	Config     interface{} `json:"config"`      // Chain configuration for the fork rules
+	Head       interface{} `json:"head"`        // SHA3 hash of the host's best owned block
+}
+
+// nodeInfo gathers and returns the same sub-protocol metadata a full node
+// would for the devp2p node record, shared between the light client and a
+// LES server.
+func (c *lesCommons) nodeInfo(head interface{}, genesis interface{}, td interface{}) *lesNodeInfo {
+	return &lesNodeInfo{
+		Network:    c.protocolManager.networkId,
+		Difficulty: td,
+		Genesis:    genesis,
+		Config:     c.chainConfig,
+		Head:       head,
+	}
+}
+
+// makeBlockchainV2Handler builds the LES/2-capable handler function used by
+// makeProtocols to serve (or, for the client, to relay) requests for a given
+// protocol version, keeping the per-version wiring in one place instead of
+// each caller repeating it.
+func (c *lesCommons) makeBlockchainV2Handler(version uint) func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	return func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+		return c.protocolManager.handle(newPeer(int(version), c.protocolManager.networkId, p, rw))
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import "testing"
+
+// TestMakeProtocols checks that lesCommons.makeProtocols actually reflects
+// the requested versions, rather than ignoring its argument.
+func TestMakeProtocols(t *testing.T) {
+	c := &lesCommons{protocolManager: &ProtocolManager{networkId: 1}}
+
+	protos := c.makeProtocols([]uint{lpv1, lpv2})
+	if len(protos) != 2 {
+		t.Fatalf("expected 2 protocols, got %d", len(protos))
+	}
+	for i, version := range []uint{lpv1, lpv2} {
+		if protos[i].Version != version {
+			t.Errorf("protocol %d: version = %d, want %d", i, protos[i].Version, version)
+		}
+		if protos[i].Length != ProtocolLengths[version] {
+			t.Errorf("protocol %d: length = %d, want %d", i, protos[i].Length, ProtocolLengths[version])
+		}
+		if protos[i].Name != "les" {
+			t.Errorf("protocol %d: name = %q, want \"les\"", i, protos[i].Name)
+		}
+	}
+
+	if got := c.makeProtocols([]uint{lpv2}); len(got) != 1 {
+		t.Fatalf("makeProtocols did not respect a narrower versions argument: got %d protocols", len(got))
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// ErrNoPeers is returned by requestDistributor when no peer is currently able
+// to serve a request.
+var ErrNoPeers = errors.New("no suitable peers available")
+
+// distReq is a request that can be sent to a certain peer, chosen by
+// canSend/getCost on any dispatch pass over the queue in which such a peer
+// is available, even if requests queued ahead of it are still waiting.
+type distReq struct {
+	getCost func(*peer) uint64
+	canSend func(*peer) bool
+	request func(*peer) func()
+
+	reqOrder uint64
+	sentChn  chan *peer
+	element  *list.Element
+}
+
+// requestDistributor fans out ODR requests to the peer with the lowest
+// projected cost that can serve and afford them, queuing anything that
+// cannot be scheduled immediately.
+type requestDistributor struct {
+	reqQueue     *list.List
+	lastReqOrder uint64
+	peers        map[*peer]struct{}
+	peerLock     sync.RWMutex
+	loopChn      chan struct{}
+	lock         sync.Mutex
+	stopChn      chan struct{}
+}
+
+// newRequestDistributor creates a new distributor that dispatches requests
+// over the peers tracked by the given peerSet.
+func newRequestDistributor(peers *peerSet, stopChn chan struct{}) *requestDistributor {
+	d := &requestDistributor{
+		reqQueue: list.New(),
+		peers:    make(map[*peer]struct{}),
+		loopChn:  make(chan struct{}, 2),
+		stopChn:  stopChn,
+	}
+	if peers != nil {
+		peers.subscribe(d)
+	}
+	go d.loop()
+	return d
+}
+
+// registerPeer implements peerSetNotify. A newly connected peer may be able
+// to serve requests that were queued while no suitable peer was available,
+// so it wakes the dispatch loop instead of waiting for the next unrelated
+// queue/delivery event.
+func (d *requestDistributor) registerPeer(p *peer) {
+	d.peerLock.Lock()
+	d.peers[p] = struct{}{}
+	d.peerLock.Unlock()
+
+	select {
+	case d.loopChn <- struct{}{}:
+	default:
+	}
+}
+
+// unregisterPeer implements peerSetNotify.
+func (d *requestDistributor) unregisterPeer(p *peer) {
+	d.peerLock.Lock()
+	defer d.peerLock.Unlock()
+
+	delete(d.peers, p)
+}
+
+// queue adds a request to the distribution queue, returning a channel that
+// receives the peer the request was finally sent to (or nil if stopChn was
+// closed before a suitable peer became available).
+func (d *requestDistributor) queue(r *distReq) chan *peer {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	r.sentChn = make(chan *peer, 1)
+	r.reqOrder = d.lastReqOrder
+	d.lastReqOrder++
+	r.element = d.reqQueue.PushBack(r)
+
+	select {
+	case d.loopChn <- struct{}{}:
+	default:
+	}
+	return r.sentChn
+}
+
+// loop repeatedly scans the queue for a request/peer pairing that can be
+// served right now, in FIFO order among requests that became eligible.
+func (d *requestDistributor) loop() {
+	for {
+		select {
+		case <-d.stopChn:
+			// Closing sentChn hands nil to every request still waiting.
+			d.lock.Lock()
+			for e := d.reqQueue.Front(); e != nil; e = e.Next() {
+				close(e.Value.(*distReq).sentChn)
+			}
+			d.reqQueue.Init()
+			d.lock.Unlock()
+			return
+		case <-d.loopChn:
+			d.tryDispatch()
+		}
+	}
+}
+
+func (d *requestDistributor) tryDispatch() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for e := d.reqQueue.Front(); e != nil; {
+		next := e.Next()
+		r := e.Value.(*distReq)
+		if p := d.bestPeer(r); p != nil {
+			if p.fcServer != nil {
+				p.fcServer.reserve(r.getCost(p))
+			}
+			d.reqQueue.Remove(e)
+			if fn := r.request(p); fn != nil {
+				go fn()
+			}
+			r.sentChn <- p
+			close(r.sentChn)
+		}
+		e = next
+	}
+}
+
+// bestPeer returns the connected peer best able to serve the request right
+// now (lowest projected cost among peers whose buffer can actually afford
+// it), or nil if no peer currently can - in which case the request stays
+// queued until one can, rather than being sent to an overloaded server.
+func (d *requestDistributor) bestPeer(r *distReq) *peer {
+	d.peerLock.RLock()
+	defer d.peerLock.RUnlock()
+
+	var best *peer
+	var bestCost uint64
+	for p := range d.peers {
+		if !r.canSend(p) {
+			continue
+		}
+		cost := r.getCost(p)
+		if p.fcServer != nil && !p.fcServer.canAfford(cost) {
+			continue
+		}
+		if best == nil || cost < bestCost {
+			best, bestCost = p, cost
+		}
+	}
+	return best
+}
@@ -0,0 +1,177 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"sync"
+	"time"
+)
+
+// msgCost is a single entry of a peer's per-message cost table (MRC),
+// received during the LES handshake: baseCost is charged once per message,
+// reqCost is charged per unit of the request's size (e.g. per header, per
+// proof key).
+type msgCost struct {
+	baseCost, reqCost uint64
+}
+
+// serverFlowControl tracks this client's model of a single server's buffer:
+// its capacity, recharge rate and current estimated value (BV), plus the
+// per-message cost table advertised by that server. It lets reqDist pick
+// only peers that can actually afford a request right now instead of
+// round-robining across peers regardless of load.
+type serverFlowControl struct {
+	lock sync.Mutex
+
+	bufLimit    uint64
+	bufValue    uint64
+	minRecharge uint64 // recharge rate in buffer units per second
+	lastUpdate  time.Time
+
+	costTable map[uint64]msgCost
+}
+
+// newServerFlowControl creates the flow control tracker for a newly
+// connected peer, seeded with the buffer parameters and cost table received
+// during the LES handshake.
+func newServerFlowControl(bufLimit, minRecharge uint64, costTable map[uint64]msgCost) *serverFlowControl {
+	return &serverFlowControl{
+		bufLimit:    bufLimit,
+		bufValue:    bufLimit,
+		minRecharge: minRecharge,
+		lastUpdate:  time.Now(),
+		costTable:   costTable,
+	}
+}
+
+// recharge brings bufValue up to date assuming it has been recharging at
+// minRecharge per second since lastUpdate, capped at bufLimit.
+func (fc *serverFlowControl) recharge() {
+	now := time.Now()
+	if d := now.Sub(fc.lastUpdate); d > 0 {
+		fc.bufValue += uint64(d.Seconds() * float64(fc.minRecharge))
+		if fc.bufValue > fc.bufLimit {
+			fc.bufValue = fc.bufLimit
+		}
+		fc.lastUpdate = now
+	}
+}
+
+// costOf computes the cost of a request of the given size for msgCode,
+// based on the server's advertised cost table.
+func (fc *serverFlowControl) costOf(msgCode uint64, amount int) uint64 {
+	c, ok := fc.costTable[msgCode]
+	if !ok {
+		return 0
+	}
+	return c.baseCost + c.reqCost*uint64(amount)
+}
+
+// reqCost computes what it would cost to send msgCode to p for a request
+// carrying amount items (e.g. headers, proof keys), using the cost table p
+// advertised during the handshake. If p hasn't completed its handshake yet
+// (fcServer == nil), it falls back to amount so callers comparing peers by
+// cost, such as requestDistributor.bestPeer, still get a sane ordering
+// before any cost table is known.
+func reqCost(p *peer, msgCode uint64, amount int) uint64 {
+	if p.fcServer == nil {
+		return uint64(amount)
+	}
+	return p.fcServer.costOf(msgCode, amount)
+}
+
+// canAfford reports whether the projected buffer value would stay
+// non-negative after deducting cost, without reserving it.
+func (fc *serverFlowControl) canAfford(cost uint64) bool {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.recharge()
+	return cost <= fc.bufValue
+}
+
+// reserve deducts cost from the buffer value, to be called once a request
+// actually gets sent to this server.
+func (fc *serverFlowControl) reserve(cost uint64) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.recharge()
+	if cost > fc.bufValue {
+		fc.bufValue = 0
+		return
+	}
+	fc.bufValue -= cost
+}
+
+// correct adjusts the local buffer value estimate against the authoritative
+// value the server returned with its reply, catching any drift accumulated
+// from estimating recharge and cost locally. It reports whether the server
+// turned out to be more loaded than the local estimate predicted, a signal
+// that it is consistently slower than its advertised recharge rate.
+func (fc *serverFlowControl) correct(serverBV uint64) (overloaded bool) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	overloaded = serverBV < fc.bufValue
+	fc.bufValue = serverBV
+	fc.lastUpdate = time.Now()
+	return overloaded
+}
+
+// bufferState is a point-in-time snapshot of a server's flow control state,
+// as returned by the debug_lesFlowControl RPC.
+type bufferState struct {
+	BufLimit    uint64
+	BufValue    uint64
+	MinRecharge uint64
+}
+
+func (fc *serverFlowControl) state() bufferState {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.recharge()
+	return bufferState{fc.bufLimit, fc.bufValue, fc.minRecharge}
+}
+
+// FlowControlAPI exposes each connected server peer's flow control buffer
+// state via debug_lesFlowControl, for diagnosing throughput problems on
+// heterogeneous LES servers.
+type FlowControlAPI struct {
+	peers *peerSet
+}
+
+// NewFlowControlAPI creates the RPC API reporting flow control state for the
+// peers tracked by the given peerSet.
+func NewFlowControlAPI(peers *peerSet) *FlowControlAPI {
+	return &FlowControlAPI{peers: peers}
+}
+
+// FlowControlState returns the current buffer state of every connected
+// server peer, keyed by peer id.
+func (api *FlowControlAPI) FlowControlState() map[string]bufferState {
+	states := make(map[string]bufferState)
+	for _, id := range api.peers.AllPeerIDs() {
+		p := api.peers.Peer(id)
+		if p == nil || p.fcServer == nil {
+			continue
+		}
+		states[id] = p.fcServer.state()
+	}
+	return states
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"testing"
+	"time"
+)
+
+// TestServerFlowControlReserve checks that reserve deducts the requested
+// cost from the buffer value, floors at zero rather than underflowing, and
+// that recharge brings it back up over time, capped at bufLimit.
+func TestServerFlowControlReserve(t *testing.T) {
+	fc := newServerFlowControl(100, 1000, nil)
+
+	fc.reserve(40)
+	if got := fc.state().BufValue; got != 60 {
+		t.Fatalf("bufValue = %d, want 60", got)
+	}
+
+	// Reserving more than what's left must floor at zero, not underflow.
+	fc.reserve(1000)
+	if got := fc.state().BufValue; got != 0 {
+		t.Fatalf("bufValue = %d, want 0 after over-spending", got)
+	}
+
+	// minRecharge is 1000/s, so after a full second the buffer should be
+	// capped at bufLimit (100) rather than recharging past it.
+	fc.lastUpdate = time.Now().Add(-time.Second)
+	if got := fc.state().BufValue; got != 100 {
+		t.Fatalf("bufValue = %d, want capped at bufLimit (100)", got)
+	}
+}
+
+// TestServerFlowControlCorrect checks that correct adopts the server's
+// authoritative buffer value and reports whether it was lower (overloaded)
+// than the local estimate.
+func TestServerFlowControlCorrect(t *testing.T) {
+	fc := newServerFlowControl(100, 1000, nil)
+	fc.reserve(10) // local estimate: 90
+
+	if overloaded := fc.correct(90); overloaded {
+		t.Fatalf("expected not overloaded when server agrees with local estimate")
+	}
+	if overloaded := fc.correct(50); !overloaded {
+		t.Fatalf("expected overloaded when server reports a lower value than the local estimate")
+	}
+	if got := fc.state().BufValue; got != 50 {
+		t.Fatalf("bufValue = %d, want 50 after correct", got)
+	}
+}
+
+// TestServerFlowControlCostOf checks that costOf combines a message's base
+// cost with its per-unit cost according to the peer's advertised cost table,
+// and returns zero for message codes absent from that table.
+func TestServerFlowControlCostOf(t *testing.T) {
+	fc := newServerFlowControl(100, 1000, map[uint64]msgCost{
+		GetBlockBodiesMsg: {baseCost: 5, reqCost: 2},
+	})
+	if got := fc.costOf(GetBlockBodiesMsg, 3); got != 11 {
+		t.Fatalf("costOf = %d, want 11 (5 base + 3*2)", got)
+	}
+	if got := fc.costOf(GetReceiptsMsg, 3); got != 0 {
+		t.Fatalf("costOf for an unknown message code = %d, want 0", got)
+	}
+}
+
+// TestReqCostFallback checks that reqCost falls back to the raw item count
+// when the peer's cost table isn't known yet (fcServer == nil), so
+// distReq.getCost still returns a sane value for peer comparisons before the
+// handshake completes.
+func TestReqCostFallback(t *testing.T) {
+	p := &peer{}
+	if got := reqCost(p, GetBlockBodiesMsg, 3); got != 3 {
+		t.Fatalf("reqCost without a flow control table = %d, want 3", got)
+	}
+
+	p.fcServer = newServerFlowControl(100, 1000, map[uint64]msgCost{
+		GetBlockBodiesMsg: {baseCost: 5, reqCost: 2},
+	})
+	if got := reqCost(p, GetBlockBodiesMsg, 3); got != 11 {
+		t.Fatalf("reqCost with a flow control table = %d, want 11", got)
+	}
+}
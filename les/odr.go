@@ -0,0 +1,148 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/athereum/go-athereum/athdb"
+	"github.com/athereum/go-athereum/core"
+	"github.com/athereum/go-athereum/core/bloombits"
+	"github.com/athereum/go-athereum/light"
+)
+
+// bloomServiceThreads is the number of goroutines used to service parallel
+// bloom bit retrievals coming in from the filter system.
+const bloomServiceThreads = 16
+
+// LesOdr implements light.OdrBackend, satisfying on-demand data requests of
+// the light client by retrieving the missing trie nodes, receipts, CHT/
+// BloomTrie proofs or tx status from connected LES servers.
+type LesOdr struct {
+	db                           athdb.Database
+	chtIndexer, bloomTrieIndexer *core.ChainIndexer
+	bloomIndexer                 *core.ChainIndexer
+	retriever                    *retrieveManager
+	stopChn                      chan struct{}
+}
+
+// NewLesOdr creates an ODR backend serving the given indexers through the
+// retrieveManager.
+func NewLesOdr(db athdb.Database, chtIndexer, bloomTrieIndexer, bloomIndexer *core.ChainIndexer, retriever *retrieveManager) *LesOdr {
+	return &LesOdr{
+		db:               db,
+		chtIndexer:       chtIndexer,
+		bloomTrieIndexer: bloomTrieIndexer,
+		bloomIndexer:     bloomIndexer,
+		retriever:        retriever,
+		stopChn:          make(chan struct{}),
+	}
+}
+
+// Stop cancels all pending retrievals.
+func (odr *LesOdr) Stop() {
+	close(odr.stopChn)
+}
+
+// Database returns the ODR backend database.
+func (odr *LesOdr) Database() athdb.Database {
+	return odr.db
+}
+
+// ChtIndexer returns the CHT chain indexer.
+func (odr *LesOdr) ChtIndexer() *core.ChainIndexer {
+	return odr.chtIndexer
+}
+
+// BloomTrieIndexer returns the BloomTrie chain indexer that backs
+// GetHelperTrieProofsMsg replies for bloom filter section lookups.
+func (odr *LesOdr) BloomTrieIndexer() *core.ChainIndexer {
+	return odr.bloomTrieIndexer
+}
+
+// BloomIndexer returns the raw (unbatched) bloombits chain indexer.
+func (odr *LesOdr) BloomIndexer() *core.ChainIndexer {
+	return odr.bloomIndexer
+}
+
+// Retrieve queries a remote LES server to satisfy req, blocking until it is
+// answered, ctx is cancelled or the ODR backend is stopped. A peer that times
+// out is not reported as an error; the request is retried with another peer.
+func (odr *LesOdr) Retrieve(ctx context.Context, req light.OdrRequest) error {
+	lreq := LesRequest(req)
+	reqID := genReqID()
+
+	rq := &distReq{
+		getCost: lreq.GetCost,
+		canSend: lreq.CanSend,
+		request: func(p *peer) func() {
+			return func() { lreq.Request(reqID, p) }
+		},
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-odr.stopChn:
+		}
+		close(stop)
+	}()
+	return odr.retriever.retrieve(reqID, rq, func(p *peer, msg interface{}) error {
+		return lreq.Validate(odr.db, msg)
+	}, stop)
+}
+
+// startBloomHandlers launches the workers that answer ath_getLogs bloom bit
+// retrievals by routing them through the BloomRequest ODR path instead of
+// requiring every section to be downloaded and indexed locally.
+func (odr *LesOdr) startBloomHandlers(bloomRequests chan chan *bloombits.Retrieval) {
+	for i := 0; i < bloomServiceThreads; i++ {
+		go func() {
+			for {
+				select {
+				case <-odr.stopChn:
+					return
+				case req := <-bloomRequests:
+					task := <-req
+					sections, _, sectionHead := odr.bloomTrieIndexer.Sections()
+					if sections == 0 {
+						req <- task
+						continue
+					}
+					bloomTrieNum := sections - 1
+					breq := &BloomRequest{
+						BloomTrieNum:   bloomTrieNum,
+						BloomTrieRoot:  light.GetBloomTrieRoot(odr.db, bloomTrieNum, sectionHead),
+						BitIdx:         task.Bit,
+						SectionIdxList: task.Sections,
+					}
+					if err := odr.Retrieve(context.Background(), breq); err == nil {
+						task.Bitsets = breq.BitSets
+					}
+					req <- task
+				}
+			}
+		}()
+	}
+}
+
+var reqIDCounter uint64
+
+func genReqID() uint64 {
+	return atomic.AddUint64(&reqIDCounter, 1)
+}
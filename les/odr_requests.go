@@ -0,0 +1,376 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/athereum/go-athereum/athdb"
+	"github.com/athereum/go-athereum/common"
+	"github.com/athereum/go-athereum/core/rawdb"
+	"github.com/athereum/go-athereum/core/types"
+	"github.com/athereum/go-athereum/crypto"
+	"github.com/athereum/go-athereum/light"
+	"github.com/athereum/go-athereum/rlp"
+)
+
+// LesOdrRequest is satisfied by every request type that can be served over
+// the LES wire protocol: it knows how much it costs a given peer to answer,
+// whether that peer is even allowed to try, how to encode itself onto the
+// wire, and how to validate the reply once it comes back.
+type LesOdrRequest interface {
+	GetCost(*peer) uint64
+	CanSend(*peer) bool
+	Request(uint64, *peer) error
+	Validate(db athdb.Database, msg interface{}) error
+}
+
+// LesRequest converts a generic light.OdrRequest coming from the ODR layer
+// into the concrete LesOdrRequest implementation able to serve it over the
+// wire.
+func LesRequest(req light.OdrRequest) LesOdrRequest {
+	switch r := req.(type) {
+	case *light.BlockRequest:
+		return (*BlockRequest)(r)
+	case *light.ReceiptsRequest:
+		return (*ReceiptsRequest)(r)
+	case *light.TrieRequest:
+		return (*TrieRequest)(r)
+	case *light.CodeRequest:
+		return (*CodeRequest)(r)
+	case *light.ChtRequest:
+		return (*ChtRequest)(r)
+	case *BloomRequest:
+		return r
+	case *TxStatusRequest:
+		return r
+	case *CheckpointRequest:
+		return r
+	}
+	return nil
+}
+
+// BlockRequest is the ODR request type for retrieving a whole block body.
+type BlockRequest light.BlockRequest
+
+// GetCost returns the cost of processing the request, taken from the cost
+// table the peer advertised during the handshake.
+func (r *BlockRequest) GetCost(p *peer) uint64 { return reqCost(p, GetBlockBodiesMsg, 1) }
+
+// CanSend reports whether the given peer can serve the request.
+func (r *BlockRequest) CanSend(p *peer) bool { return true }
+
+// Request sends the request to the given peer.
+func (r *BlockRequest) Request(reqID uint64, p *peer) error {
+	return p.RequestBodies(reqID, []common.Hash{r.Hash})
+}
+
+// Validate checks the reply body hashes to the transaction/uncle roots of the
+// locally held, already-verified header for this block.
+func (r *BlockRequest) Validate(db athdb.Database, msg interface{}) error {
+	bodies, ok := msg.([]*types.Body)
+	if !ok || len(bodies) != 1 {
+		return errInvalidMessage
+	}
+	header := rawdb.ReadHeader(db, r.Hash, r.Number)
+	if header == nil {
+		return errInvalidMessage
+	}
+	body := bodies[0]
+	if types.DeriveSha(types.Transactions(body.Transactions)) != header.TxHash {
+		return fmt.Errorf("transaction root mismatch for block %x", r.Hash)
+	}
+	if types.CalcUncleHash(body.Uncles) != header.UncleHash {
+		return fmt.Errorf("uncle root mismatch for block %x", r.Hash)
+	}
+	r.Body = body
+	return nil
+}
+
+// ReceiptsRequest is the ODR request type for retrieving block receipts.
+type ReceiptsRequest light.ReceiptsRequest
+
+func (r *ReceiptsRequest) GetCost(p *peer) uint64 { return reqCost(p, GetReceiptsMsg, 1) }
+func (r *ReceiptsRequest) CanSend(p *peer) bool   { return true }
+func (r *ReceiptsRequest) Request(reqID uint64, p *peer) error {
+	return p.RequestReceipts(reqID, []common.Hash{r.Hash})
+}
+
+// Validate checks the reply receipts hash to the receipt root of the locally
+// held, already-verified header for this block.
+func (r *ReceiptsRequest) Validate(db athdb.Database, msg interface{}) error {
+	receipts, ok := msg.([]types.Receipts)
+	if !ok || len(receipts) != 1 {
+		return errInvalidMessage
+	}
+	header := rawdb.ReadHeader(db, r.Hash, r.Number)
+	if header == nil {
+		return errInvalidMessage
+	}
+	if types.DeriveSha(receipts[0]) != header.ReceiptHash {
+		return fmt.Errorf("receipt root mismatch for block %x", r.Hash)
+	}
+	r.Receipts = receipts[0]
+	return nil
+}
+
+// TrieRequest is the ODR request type for retrieving state/storage trie
+// nodes. Each TrieRequest is sent on its own, as a single-key GetProofsV2Msg
+// (GetProofsV1Msg on LES/1 peers); requests are not batched with each other.
+// The LES/2 reply is one flat NodeList, and Validate walks the requested key
+// against that node set.
+type TrieRequest light.TrieRequest
+
+func (r *TrieRequest) GetCost(p *peer) uint64 {
+	if p.version >= lpv2 {
+		return reqCost(p, GetProofsV2Msg, 1)
+	}
+	return reqCost(p, GetProofsV1Msg, 1)
+}
+func (r *TrieRequest) CanSend(p *peer) bool   { return true }
+func (r *TrieRequest) Request(reqID uint64, p *peer) error {
+	return p.RequestProofs(reqID, []ProofReq{{
+		AccKey: r.Id.AccKey,
+		Key:    r.Key,
+	}})
+}
+
+// Validate walks r.Key against the node set the reply carries, verifying it
+// proves a path all the way to r.Id.Root - the trusted state/storage root the
+// request was made against - rather than just checking the reply's shape.
+func (r *TrieRequest) Validate(db athdb.Database, msg interface{}) error {
+	nodes, ok := msg.(NodeList)
+	if !ok {
+		// LES/1 replies (ProofsV1Msg) carry one node list per requested key.
+		if v1, isV1 := msg.([]NodeList); isV1 && len(v1) == 1 {
+			nodes, ok = v1[0], true
+		}
+	}
+	if !ok {
+		return errInvalidMessage
+	}
+	if _, err := nodes.verifyProof(r.Id.Root, r.Key); err != nil {
+		return fmt.Errorf("merkle proof verification failed: %v", err)
+	}
+	r.Proof = nodes
+	return nil
+}
+
+// CodeRequest is the ODR request type for retrieving contract code.
+type CodeRequest light.CodeRequest
+
+func (r *CodeRequest) GetCost(p *peer) uint64 { return reqCost(p, GetCodeMsg, 1) }
+func (r *CodeRequest) CanSend(p *peer) bool   { return true }
+func (r *CodeRequest) Request(reqID uint64, p *peer) error {
+	return p.RequestCode(reqID, []CodeReq{{
+		BHash:  r.Id.BlockHash,
+		AccKey: r.Id.AccKey,
+	}})
+}
+
+// Validate checks the returned code blob hashes to the code hash the request
+// was made for, since code has no Merkle proof of its own.
+func (r *CodeRequest) Validate(db athdb.Database, msg interface{}) error {
+	data, ok := msg.([][]byte)
+	if !ok || len(data) != 1 {
+		return errInvalidMessage
+	}
+	if crypto.Keccak256Hash(data[0]) != r.Hash {
+		return fmt.Errorf("code hash mismatch for %x", r.Hash)
+	}
+	r.Data = data[0]
+	return nil
+}
+
+// ChtRequest is the ODR request type for retrieving a Canonical Hash Trie
+// proof of a historical (header, td) pair.
+type ChtRequest light.ChtRequest
+
+func (r *ChtRequest) GetCost(p *peer) uint64 { return reqCost(p, GetHelperTrieProofsMsg, 1) }
+func (r *ChtRequest) CanSend(p *peer) bool   { return true }
+func (r *ChtRequest) Request(reqID uint64, p *peer) error {
+	return p.RequestHelperTrieProofs(reqID, []HelperTrieReq{{
+		Type:    htCanonical,
+		TrieIdx: r.ChtNum,
+		Key:     encodeChtKey(r.BlockNum),
+		AuxReq:  auxHeader,
+	}})
+}
+
+// chtNode is the RLP value stored at a CHT leaf: the hash and total
+// difficulty of the header for the requested block number.
+type chtNode struct {
+	Hash common.Hash
+	Td   *big.Int
+}
+
+// Validate verifies the CHT leaf for r.BlockNum against r.ChtRoot - the
+// section root the client already trusts locally - then checks that the
+// full header served alongside the proof as auxiliary data actually hashes
+// to the hash the CHT leaf commits to.
+func (r *ChtRequest) Validate(db athdb.Database, msg interface{}) error {
+	resp, ok := msg.(HelperTrieResps)
+	if !ok {
+		return errInvalidMessage
+	}
+	value, err := resp.Proofs.verifyProof(r.ChtRoot, encodeChtKey(r.BlockNum))
+	if err != nil {
+		return fmt.Errorf("merkle proof verification failed: %v", err)
+	}
+	var node chtNode
+	if err := rlp.DecodeBytes(value, &node); err != nil {
+		return fmt.Errorf("invalid cht node: %v", err)
+	}
+	if len(resp.AuxData) != 1 {
+		return errInvalidMessage
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(resp.AuxData[0], header); err != nil {
+		return fmt.Errorf("invalid header auxiliary data: %v", err)
+	}
+	if header.Hash() != node.Hash {
+		return fmt.Errorf("header hash %x does not match cht entry %x", header.Hash(), node.Hash)
+	}
+	r.Header = header
+	r.Td = node.Td
+	return nil
+}
+
+// BloomRequest is the ODR request type added for LES/2 to retrieve BloomTrie
+// proofs for one or more bloom bit sections in a single round-trip, removing
+// the need to download and index every section locally just to serve
+// ath_getLogs.
+type BloomRequest struct {
+	BloomTrieNum   uint64
+	BitIdx         uint
+	SectionIdxList []uint64
+
+	BloomTrieRoot common.Hash
+	Proofs        NodeList
+	BitSets       [][]byte
+}
+
+func (r *BloomRequest) GetCost(p *peer) uint64 {
+	return reqCost(p, GetHelperTrieProofsMsg, len(r.SectionIdxList))
+}
+func (r *BloomRequest) CanSend(p *peer) bool   { return p.version >= lpv2 }
+func (r *BloomRequest) Request(reqID uint64, p *peer) error {
+	reqs := make([]HelperTrieReq, len(r.SectionIdxList))
+	for i, sectionIdx := range r.SectionIdxList {
+		reqs[i] = HelperTrieReq{
+			Type:    htBloomBits,
+			TrieIdx: r.BloomTrieNum,
+			Key:     encodeBitBloomKey(r.BitIdx, sectionIdx),
+		}
+	}
+	return p.RequestHelperTrieProofs(reqID, reqs)
+}
+
+// Validate verifies each requested section's bloom bits against
+// r.BloomTrieRoot using the single shared node set the reply carries,
+// instead of trusting whatever bitsets the server attached.
+func (r *BloomRequest) Validate(db athdb.Database, msg interface{}) error {
+	resp, ok := msg.(HelperTrieResps)
+	if !ok {
+		return errInvalidMessage
+	}
+	bitSets := make([][]byte, len(r.SectionIdxList))
+	for i, sectionIdx := range r.SectionIdxList {
+		key := encodeBitBloomKey(r.BitIdx, sectionIdx)
+		value, err := resp.Proofs.verifyProof(r.BloomTrieRoot, key)
+		if err != nil {
+			return fmt.Errorf("bloom trie proof verification failed for section %d: %v", sectionIdx, err)
+		}
+		bitSets[i] = value
+	}
+	r.Proofs = resp.Proofs
+	r.BitSets = bitSets
+	return nil
+}
+
+// TxStatusRequest is the ODR request type added for LES/2 to retrieve the
+// mempool/chain status of a previously submitted transaction, without
+// waiting for a full receipts round-trip.
+type TxStatusRequest struct {
+	Hashes []common.Hash
+	Status []TxStatus
+}
+
+func (r *TxStatusRequest) GetCost(p *peer) uint64 {
+	return reqCost(p, GetTxStatusMsg, len(r.Hashes))
+}
+func (r *TxStatusRequest) CanSend(p *peer) bool   { return p.version >= lpv2 }
+func (r *TxStatusRequest) Request(reqID uint64, p *peer) error {
+	return p.RequestTxStatus(reqID, r.Hashes)
+}
+func (r *TxStatusRequest) Validate(db athdb.Database, msg interface{}) error {
+	status, ok := msg.([]TxStatus)
+	if !ok {
+		return errInvalidMessage
+	}
+	if len(status) != len(r.Hashes) {
+		return errInvalidMessage
+	}
+	r.Status = status
+	return nil
+}
+
+// CheckpointRequest is the ODR request type added for checkpoint-trusted
+// sync: it fetches a peer's locally adopted checkpoint for a section index
+// when a client would rather ask a peer directly than depend on the
+// on-chain checkpoint oracle contract being reachable.
+type CheckpointRequest struct {
+	SectionIdx uint64
+	Data       CheckpointData
+}
+
+func (r *CheckpointRequest) GetCost(p *peer) uint64 { return reqCost(p, GetCheckpointDataMsg, 1) }
+func (r *CheckpointRequest) CanSend(p *peer) bool   { return p.version >= lpv2 }
+func (r *CheckpointRequest) Request(reqID uint64, p *peer) error {
+	return p.RequestCheckpoint(reqID, r.SectionIdx)
+}
+func (r *CheckpointRequest) Validate(db athdb.Database, msg interface{}) error {
+	data, ok := msg.(CheckpointData)
+	if !ok {
+		return errInvalidMessage
+	}
+	r.Data = data
+	return nil
+}
+
+var errInvalidMessage = errResp(ErrInvalidResponse, "invalid message type for request")
+
+func encodeChtKey(num uint64) []byte {
+	key := make([]byte, 8)
+	for i := 7; i >= 0; i-- {
+		key[i] = byte(num)
+		num >>= 8
+	}
+	return key
+}
+
+func encodeBitBloomKey(bitIdx uint, sectionIdx uint64) []byte {
+	key := make([]byte, 10)
+	key[0] = byte(bitIdx >> 8)
+	key[1] = byte(bitIdx)
+	for i := 9; i >= 2; i-- {
+		key[i] = byte(sectionIdx)
+		sectionIdx >>= 8
+	}
+	return key
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeChtKey(t *testing.T) {
+	tests := []struct {
+		num uint64
+		key []byte
+	}{
+		{0, []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+		{1, []byte{0, 0, 0, 0, 0, 0, 0, 1}},
+		{0x0102030405060708, []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+	}
+	for _, test := range tests {
+		if got := encodeChtKey(test.num); !bytes.Equal(got, test.key) {
+			t.Errorf("encodeChtKey(%d) = %x, want %x", test.num, got, test.key)
+		}
+	}
+}
+
+func TestEncodeBitBloomKey(t *testing.T) {
+	key := encodeBitBloomKey(0x0102, 0x0304050607080910)
+	want := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0x10}
+	if !bytes.Equal(key, want) {
+		t.Errorf("encodeBitBloomKey() = %x, want %x", key, want)
+	}
+	// Distinct bit indexes or section indexes must not collide.
+	if bytes.Equal(encodeBitBloomKey(1, 5), encodeBitBloomKey(2, 5)) {
+		t.Errorf("encodeBitBloomKey should vary with bitIdx")
+	}
+	if bytes.Equal(encodeBitBloomKey(1, 5), encodeBitBloomKey(1, 6)) {
+		t.Errorf("encodeBitBloomKey should vary with sectionIdx")
+	}
+}
@@ -0,0 +1,395 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/athereum/go-athereum/athdb"
+	"github.com/athereum/go-athereum/common"
+	"github.com/athereum/go-athereum/core/types"
+	"github.com/athereum/go-athereum/crypto"
+	"github.com/athereum/go-athereum/p2p"
+	"github.com/athereum/go-athereum/rlp"
+	"github.com/athereum/go-athereum/trie"
+)
+
+var (
+	errAlreadyRegistered = fmt.Errorf("peer is already registered")
+	errNotRegistered     = fmt.Errorf("peer is not registered")
+)
+
+// keyValueEntry and keyValueList implement the key/value pair list used by
+// the status message to exchange protocol options between peers.
+type keyValueEntry struct {
+	Key   string
+	Value rlp.RawValue
+}
+type keyValueList []keyValueEntry
+type keyValueMap map[string]rlp.RawValue
+
+func (l keyValueList) add(key string, val interface{}) keyValueList {
+	var entry keyValueEntry
+	entry.Key = key
+	if val == nil {
+		val = uint64(0)
+	}
+	enc, err := rlp.EncodeToBytes(val)
+	if err == nil {
+		entry.Value = enc
+	}
+	return append(l, entry)
+}
+
+func (l keyValueList) decode() keyValueMap {
+	m := make(keyValueMap)
+	for _, entry := range l {
+		m[entry.Key] = entry.Value
+	}
+	return m
+}
+
+func (m keyValueMap) get(key string, val interface{}) error {
+	enc, ok := m[key]
+	if !ok {
+		return errResp(ErrMissingKey, "%s", key)
+	}
+	if val == nil {
+		return nil
+	}
+	return rlp.DecodeBytes(enc, val)
+}
+
+// NodeList is a flattened, deduplicated set of trie nodes returned in
+// response to a batched proof request. Unlike the LPV1 proof encoding, which
+// sent one independent node list per requested key, a single NodeList can
+// satisfy every key requested in the same message because shared nodes
+// (e.g. the root and upper layers of the trie) are only transmitted once;
+// the verifier walks each requested key against this shared node set.
+type NodeList []rlp.RawValue
+
+// NodeSet turns the flattened node list back into a keyed proof database that
+// trie.VerifyProof can walk a given key against, keyed by each node's hash.
+func (n NodeList) NodeSet() athdb.Database {
+	db := athdb.NewMemDatabase()
+	for _, node := range n {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	return db
+}
+
+// verifyProof checks that key is provably present (or absent) under root in
+// the trie reconstructed from n, returning the leaf value it resolves to.
+func (n NodeList) verifyProof(root common.Hash, key []byte) ([]byte, error) {
+	return trie.VerifyProof(root, key, n.NodeSet())
+}
+
+// peer represents a connected LES peer, extending p2p.Peer with LES protocol
+// state such as the advertised chain head and per-message request ids.
+type peer struct {
+	*p2p.Peer
+
+	rw p2p.MsgReadWriter
+
+	version int    // Protocol version negotiated
+	network uint64 // Network id being served
+
+	id string
+
+	headInfo *announceData
+	lock     sync.RWMutex
+
+	announceChn chan announceData
+
+	poolEntry      *poolEntry
+	hasBlock       func(common.Hash, uint64) bool
+	responseErrors int
+
+	fcServer *serverFlowControl // this client's model of the peer's request buffer
+}
+
+func newPeer(version int, network uint64, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
+	id := p.ID()
+	return &peer{
+		Peer:        p,
+		rw:          rw,
+		version:     version,
+		network:     network,
+		id:          fmt.Sprintf("%x", id[:8]),
+		announceChn: make(chan announceData, 4),
+	}
+}
+
+// Head retrieves the current head hash, number and total difficulty known to
+// the peer.
+func (p *peer) Head() (common.Hash, *big.Int) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if p.headInfo == nil {
+		return common.Hash{}, nil
+	}
+	return p.headInfo.Hash, p.headInfo.Td
+}
+
+// ProofReq is a request for a single state/storage trie proof, sent as part
+// of a batched GetProofsV2Msg.
+type ProofReq struct {
+	BHash     common.Hash
+	AccKey    []byte
+	Key       []byte
+	FromLevel uint
+}
+
+// CodeReq is a request for a single piece of contract code.
+type CodeReq struct {
+	BHash  common.Hash
+	AccKey []byte
+}
+
+// RequestBodies fetches a batch of block bodies by hash.
+func (p *peer) RequestBodies(reqID uint64, hashes []common.Hash) error {
+	return p2p.Send(p.rw, GetBlockBodiesMsg, struct {
+		ReqID  uint64
+		Hashes []common.Hash
+	}{reqID, hashes})
+}
+
+// RequestReceipts fetches a batch of block receipts by block hash.
+func (p *peer) RequestReceipts(reqID uint64, hashes []common.Hash) error {
+	return p2p.Send(p.rw, GetReceiptsMsg, struct {
+		ReqID  uint64
+		Hashes []common.Hash
+	}{reqID, hashes})
+}
+
+// RequestProofs fetches a batch of state/storage trie proofs, encoded with
+// GetProofsV2Msg on LES/2 peers so the server can reply with a single
+// deduplicated NodeList rather than one proof per key.
+func (p *peer) RequestProofs(reqID uint64, reqs []ProofReq) error {
+	if p.version >= lpv2 {
+		return p2p.Send(p.rw, GetProofsV2Msg, struct {
+			ReqID uint64
+			Reqs  []ProofReq
+		}{reqID, reqs})
+	}
+	return p2p.Send(p.rw, GetProofsV1Msg, struct {
+		ReqID uint64
+		Reqs  []ProofReq
+	}{reqID, reqs})
+}
+
+// RequestCode fetches a batch of contract code blobs.
+func (p *peer) RequestCode(reqID uint64, reqs []CodeReq) error {
+	return p2p.Send(p.rw, GetCodeMsg, struct {
+		ReqID uint64
+		Reqs  []CodeReq
+	}{reqID, reqs})
+}
+
+// SendTxs sends a batch of transactions to be added to the remote server's
+// mempool.
+func (p *peer) SendTxs(txs types.Transactions) error {
+	if p.version >= lpv2 {
+		return p2p.Send(p.rw, SendTxV2Msg, txs)
+	}
+	return p2p.Send(p.rw, SendTxMsg, txs)
+}
+
+// SendHelperTrieProofs sends a batch of helper trie (CHT / BloomTrie) merkle
+// proofs, added for LES/2.
+func (p *peer) SendHelperTrieProofs(reqID, bv uint64, resp HelperTrieResps) error {
+	return p2p.Send(p.rw, HelperTrieProofsMsg, struct {
+		ReqID, BV uint64
+		Resp      HelperTrieResps
+	}{reqID, bv, resp})
+}
+
+// RequestHelperTrieProofs fetches a batch of merkle proofs for the given CHT
+// or BloomTrie keys from the peer.
+func (p *peer) RequestHelperTrieProofs(reqID uint64, reqs []HelperTrieReq) error {
+	return p2p.Send(p.rw, GetHelperTrieProofsMsg, struct {
+		ReqID uint64
+		Reqs  []HelperTrieReq
+	}{reqID, reqs})
+}
+
+// SendTxStatus sends the current mempool status (pending, queued or mined)
+// for a batch of previously submitted transactions.
+func (p *peer) SendTxStatus(reqID, bv uint64, stats []TxStatus) error {
+	return p2p.Send(p.rw, TxStatusMsg, struct {
+		ReqID, BV uint64
+		Status    []TxStatus
+	}{reqID, bv, stats})
+}
+
+// RequestTxStatus fetches the inclusion status of a batch of transactions by
+// hash, without requiring a full receipts round-trip.
+func (p *peer) RequestTxStatus(reqID uint64, txHashes []common.Hash) error {
+	return p2p.Send(p.rw, GetTxStatusMsg, struct {
+		ReqID    uint64
+		TxHashes []common.Hash
+	}{reqID, txHashes})
+}
+
+// RequestCheckpoint fetches the checkpoint (signed CHT/BloomTrie roots) the
+// peer has adopted for the given section, for clients that would rather ask
+// a peer directly than depend on the on-chain checkpoint oracle contract.
+func (p *peer) RequestCheckpoint(reqID, sectionIdx uint64) error {
+	return p2p.Send(p.rw, GetCheckpointDataMsg, struct {
+		ReqID   uint64
+		Section uint64
+	}{reqID, sectionIdx})
+}
+
+// SendCheckpointData replies to a GetCheckpointDataMsg with this node's own
+// locally adopted checkpoint, for peers that would rather trust it directly
+// than depend on the on-chain checkpoint oracle contract.
+func (p *peer) SendCheckpointData(reqID, bv uint64, data CheckpointData) error {
+	return p2p.Send(p.rw, CheckpointDataMsg, struct {
+		ReqID, BV uint64
+		Data      CheckpointData
+	}{reqID, bv, data})
+}
+
+// String implements fmt.Stringer.
+func (p *peer) String() string {
+	return fmt.Sprintf("Peer %s [%s]", p.id, fmt.Sprintf("les/%d", p.version))
+}
+
+// peerSetNotify is a callback interface to notify services about added or
+// removed peers.
+type peerSetNotify interface {
+	registerPeer(*peer)
+	unregisterPeer(*peer)
+}
+
+// peerSet represents the collection of active peers currently participating
+// in the Light Atlantis sub-protocol.
+type peerSet struct {
+	peers       map[string]*peer
+	newPeerHook func(*peer)
+	notifyList  []peerSetNotify
+	lock        sync.RWMutex
+	closed      bool
+}
+
+// newPeerSet creates a new peer set to track the active participants.
+func newPeerSet() *peerSet {
+	return &peerSet{
+		peers: make(map[string]*peer),
+	}
+}
+
+// subscribe adds a service to be notified about peer addition and removal
+// events.
+func (ps *peerSet) subscribe(n peerSetNotify) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	ps.notifyList = append(ps.notifyList, n)
+	for _, p := range ps.peers {
+		n.registerPeer(p)
+	}
+}
+
+// Register injects a new peer into the working set, or returns an error if
+// the peer is already known.
+func (ps *peerSet) Register(p *peer) error {
+	ps.lock.Lock()
+	if ps.closed {
+		ps.lock.Unlock()
+		return errClosed
+	}
+	if _, ok := ps.peers[p.id]; ok {
+		ps.lock.Unlock()
+		return errAlreadyRegistered
+	}
+	ps.peers[p.id] = p
+	peers := make([]peerSetNotify, len(ps.notifyList))
+	copy(peers, ps.notifyList)
+	ps.lock.Unlock()
+
+	for _, n := range peers {
+		n.registerPeer(p)
+	}
+	return nil
+}
+
+// Unregister removes a remote peer from the active set, disabling any further
+// actions to/from that particular entity.
+func (ps *peerSet) Unregister(id string) error {
+	ps.lock.Lock()
+	p, ok := ps.peers[id]
+	if !ok {
+		ps.lock.Unlock()
+		return errNotRegistered
+	}
+	delete(ps.peers, id)
+	peers := make([]peerSetNotify, len(ps.notifyList))
+	copy(peers, ps.notifyList)
+	ps.lock.Unlock()
+
+	for _, n := range peers {
+		n.unregisterPeer(p)
+	}
+	return nil
+}
+
+// Peer retrieves the registered peer with the given id.
+func (ps *peerSet) Peer(id string) *peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return ps.peers[id]
+}
+
+// Len returns the number of peers currently active.
+func (ps *peerSet) Len() int {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return len(ps.peers)
+}
+
+// AllPeerIDs returns the ids of all registered peers.
+func (ps *peerSet) AllPeerIDs() []string {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	ids := make([]string, 0, len(ps.peers))
+	for id := range ps.peers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Close disconnects all peers. No new peers can be registered after Close
+// has returned.
+func (ps *peerSet) Close() {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	for _, p := range ps.peers {
+		p.Disconnect(p2p.DiscQuitting)
+	}
+	ps.closed = true
+}
+
+var errClosed = fmt.Errorf("peerSet is closed")
@@ -0,0 +1,233 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/athereum/go-athereum/common"
+	"github.com/athereum/go-athereum/crypto"
+	"github.com/athereum/go-athereum/p2p/discv5"
+	"github.com/athereum/go-athereum/rlp"
+)
+
+// Constants to match up protocol versions and messages
+const (
+	lpv1 = 1
+	lpv2 = 2
+)
+
+// Supported versions of the les protocol (first is primary)
+var (
+	ClientProtocolVersions    = []uint{lpv1, lpv2}
+	ServerProtocolVersions    = []uint{lpv1, lpv2}
+	AdvertiseProtocolVersions = []uint{lpv2}
+)
+
+// Number of implemented message corresponding to different protocol versions.
+var ProtocolLengths = map[uint]uint64{lpv1: 15, lpv2: 24}
+
+const (
+	NetworkId          = 1
+	ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
+	blockSafetyMargin  = 4                // safety margin applied to block ranges specified relative to head block
+)
+
+// les protocol message codes
+const (
+	// Protocol messages belonging to LPV1
+	StatusMsg          = 0x00
+	AnnounceMsg        = 0x01
+	GetBlockHeadersMsg = 0x02
+	BlockHeadersMsg    = 0x03
+	GetBlockBodiesMsg  = 0x04
+	BlockBodiesMsg     = 0x05
+	GetReceiptsMsg     = 0x06
+	ReceiptsMsg        = 0x07
+	GetProofsV1Msg     = 0x08
+	ProofsV1Msg        = 0x09
+	GetCodeMsg         = 0x0a
+	CodeMsg            = 0x0b
+	SendTxMsg          = 0x0c
+	GetHeaderProofsMsg = 0x0d
+	HeaderProofsMsg    = 0x0e
+	// Protocol messages belonging to LPV2
+	GetProofsV2Msg         = 0x0f
+	ProofsV2Msg            = 0x10
+	GetHelperTrieProofsMsg = 0x11
+	HelperTrieProofsMsg    = 0x12
+	SendTxV2Msg            = 0x13
+	GetTxStatusMsg         = 0x14
+	TxStatusMsg            = 0x15
+	GetCheckpointDataMsg   = 0x16
+	CheckpointDataMsg      = 0x17
+)
+
+type errCode int
+
+const (
+	ErrMsgTooLarge = iota
+	ErrDecode
+	ErrInvalidMsgCode
+	ErrProtocolVersionMismatch
+	ErrNetworkIdMismatch
+	ErrGenesisBlockMismatch
+	ErrNoStatusMsg
+	ErrExtraStatusMsg
+	ErrSuspendedPeer
+	ErrUselessPeer
+	ErrRequestRejected
+	ErrUnexpectedResponse
+	ErrInvalidResponse
+	ErrTooManyInvalidRequest
+	ErrMissingKey
+)
+
+func (e errCode) String() string {
+	return errorToString[int(e)]
+}
+
+var errorToString = map[int]string{
+	ErrMsgTooLarge:             "Message too long",
+	ErrDecode:                  "Invalid message",
+	ErrInvalidMsgCode:          "Invalid message code",
+	ErrProtocolVersionMismatch: "Protocol version mismatch",
+	ErrNetworkIdMismatch:       "NetworkId mismatch",
+	ErrGenesisBlockMismatch:    "Genesis block mismatch",
+	ErrNoStatusMsg:             "No status message",
+	ErrExtraStatusMsg:          "Extra status message",
+	ErrSuspendedPeer:           "Suspended peer",
+	ErrUselessPeer:             "Useless peer",
+	ErrRequestRejected:         "Request rejected",
+	ErrUnexpectedResponse:      "Unexpected response",
+	ErrInvalidResponse:         "Invalid response",
+	ErrTooManyInvalidRequest:   "Too many invalid requests made",
+	ErrMissingKey:              "Key missing from list",
+}
+
+// announceData is the network packet for the status message for the light
+// Atlantis sub protocol.
+type announceData struct {
+	Hash       common.Hash // Hash of the chain head
+	Number     uint64      // Block number of the chain head
+	Td         *big.Int    // Total difficulty of the chain head
+	ReorgDepth uint64
+	Update     keyValueList
+
+	// Sign, if non-empty, is an ECDSA signature over (Number, Hash, Td) made
+	// by an ultra-light server's node key, letting clients that trust that
+	// server's pubkey count it towards the quorum that selects their sync
+	// target. The headers up to that head are still downloaded and verified.
+	Sign []byte
+}
+
+// sign signs the head referenced by the announcement with the given key,
+// enabling ultra-light clients that trust the corresponding pubkey to adopt
+// it without independently verifying headers.
+func (a *announceData) sign(privKey *ecdsa.PrivateKey) {
+	rlp, _ := rlp.EncodeToBytes(announceBlock{a.Hash, a.Number, a.Td})
+	sig, _ := crypto.Sign(crypto.Keccak256(rlp), privKey)
+	a.Sign = sig
+}
+
+// checkSignature verifies that the announcement was signed by the holder of
+// the given pubkey.
+func (a *announceData) checkSignature(id discv5.NodeID) error {
+	rlp, _ := rlp.EncodeToBytes(announceBlock{a.Hash, a.Number, a.Td})
+	recPubkey, err := crypto.SigToPub(crypto.Keccak256(rlp), a.Sign)
+	if err != nil {
+		return err
+	}
+	if pubkey := discv5.PubkeyID(recPubkey); pubkey == id {
+		return nil
+	}
+	return errors.New("signature invalid")
+}
+
+type announceBlock struct {
+	Hash   common.Hash
+	Number uint64
+	Td     *big.Int
+}
+
+// helper trie type constants, used by GetHelperTrieProofsMsg to select which
+// auxiliary trie (CHT or BloomTrie) a given request refers to.
+const (
+	htCanonical = iota // Canonical hash trie
+	htBloomBits        // BloomBits trie
+)
+
+// AuxReq values for HelperTrieReq, selecting additional out-of-trie data that
+// should accompany the Merkle proof in the reply.
+const (
+	auxNone   = iota // No auxiliary data requested.
+	auxHeader        // Return the full RLP-encoded header a CHT leaf commits to.
+)
+
+// HelperTrieReq describes a request for a Merkle proof against one of the
+// auxiliary (CHT / BloomTrie) tries served over LES/2.
+type HelperTrieReq struct {
+	Type      uint
+	TrieIdx   uint64
+	Key       []byte
+	FromLevel uint
+	AuxReq    uint
+}
+
+// HelperTrieResps is the network packet for the helper trie proof reply,
+// which contains a single flattened, deduplicated set of trie nodes that can
+// satisfy every proof requested in the corresponding GetHelperTrieProofsMsg.
+type HelperTrieResps struct {
+	Proofs  NodeList
+	AuxData [][]byte
+}
+
+// TxStatusUnknown etc. describe where a submitted transaction currently sits,
+// so that a light client can learn its inclusion status without fetching a
+// full receipt (and the block it would live in) on every poll.
+const (
+	TxStatusUnknown = iota
+	TxStatusPending
+	TxStatusQueued
+	TxStatusIncluded
+	TxStatusError
+)
+
+// TxLookupEntry pins a transaction to the block and position it was included
+// at, once its status is TxStatusIncluded.
+type TxLookupEntry struct {
+	BlockHash  common.Hash
+	BlockIndex uint64
+	Index      uint64
+}
+
+// TxStatus is the network representation of a transaction's pool/chain
+// status, returned in reply to a GetTxStatusMsg request.
+type TxStatus struct {
+	Status uint
+	Lookup *TxLookupEntry `rlp:"nil"`
+	Error  string
+}
+
+func (e errCode) Error() string { return e.String() }
+
+func errResp(code errCode, format string, v ...interface{}) error {
+	return fmt.Errorf("%v - %v", code, fmt.Sprintf(format, v...))
+}
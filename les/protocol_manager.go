@@ -0,0 +1,387 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"crypto/ecdsa"
+	"sync"
+
+	"github.com/athereum/go-athereum/athdb"
+	"github.com/athereum/go-athereum/consensus"
+	"github.com/athereum/go-athereum/core/types"
+	"github.com/athereum/go-athereum/ath/downloader"
+	"github.com/athereum/go-athereum/event"
+	"github.com/athereum/go-athereum/light"
+	"github.com/athereum/go-athereum/log"
+	"github.com/athereum/go-athereum/p2p"
+	"github.com/athereum/go-athereum/p2p/discv5"
+	"github.com/athereum/go-athereum/params"
+)
+
+// ProtocolManager drives the LES wire protocol: it accepts peers, runs their
+// per-peer read loop and dispatches inbound messages, either answering ODR
+// requests directly (server side) or handing replies back to the
+// retrieveManager (client side).
+type ProtocolManager struct {
+	lightSync   bool
+	txpool      *light.TxPool
+	txrelay     *LesTxRelay
+	networkId   uint64
+	chainConfig *params.ChainConfig
+	blockchain  *light.LightChain
+	chainDb     athdb.Database
+	odr         *LesOdr
+	serverPool  *serverPool
+	ulc         *ultraLightClient
+	ulcSign     *ultraLightSigner
+
+	// checkpoint and checkpointSigs are the trusted checkpoint, if any, this
+	// node has adopted and is willing to relay to peers that would rather
+	// ask for it directly than depend on the on-chain checkpoint oracle
+	// contract. See SetCheckpoint.
+	checkpoint     *TrustedCheckpoint
+	checkpointSigs [][]byte
+
+	downloader *downloader.Downloader
+	peers      *peerSet
+
+	SubProtocols []p2p.Protocol
+
+	eventMux *event.TypeMux
+
+	quitSync    chan struct{}
+	noMorePeers chan struct{}
+
+	wg *sync.WaitGroup
+}
+
+// NewProtocolManager creates a new LES protocol manager. If lightSync is
+// true it runs in light-client mode, serving nothing and relaying requests
+// through odr/txrelay/serverPool; otherwise it would run as a full LES
+// server (not yet wired up in this package). The caller is expected to embed
+// the returned manager in a lesCommons and populate SubProtocols from
+// lesCommons.makeProtocols, which both the client and a future server share.
+func NewProtocolManager(chainConfig *params.ChainConfig, lightSync bool, networkId uint64, mux *event.TypeMux, engine consensus.Engine, peers *peerSet, blockchain *light.LightChain, txpool *light.TxPool, chainDb athdb.Database, odr *LesOdr, txrelay *LesTxRelay, serverPool *serverPool, quitSync chan struct{}, wg *sync.WaitGroup) (*ProtocolManager, error) {
+	pm := &ProtocolManager{
+		lightSync:   lightSync,
+		eventMux:    mux,
+		blockchain:  blockchain,
+		chainConfig: chainConfig,
+		chainDb:     chainDb,
+		odr:         odr,
+		txrelay:     txrelay,
+		serverPool:  serverPool,
+		peers:       peers,
+		networkId:   networkId,
+		quitSync:    quitSync,
+		noMorePeers: make(chan struct{}),
+		wg:          wg,
+	}
+	if lightSync {
+		pm.downloader = downloader.New(downloader.LightSync, chainDb, mux, nil, blockchain, pm.removePeer)
+	}
+	return pm, nil
+}
+
+// removePeer is the downloader's dropPeer callback, disconnecting a peer that
+// misbehaved badly enough during sync for the downloader to give up on it.
+func (pm *ProtocolManager) removePeer(id string) {
+	peer := pm.peers.Peer(id)
+	if peer == nil {
+		return
+	}
+	peer.Disconnect(p2p.DiscUselessPeer)
+}
+
+// SetUltraLight configures the protocol manager to accept signed head
+// announcements from the given trusted ultra-light servers. Once fraction
+// percent of them agree on a head, the downloader is started towards it; the
+// headers up to it are still downloaded and verified by the downloader.
+func (pm *ProtocolManager) SetUltraLight(servers []string, fraction int) {
+	if len(servers) == 0 {
+		return
+	}
+	pm.ulc = newUltraLightClient(servers, fraction)
+}
+
+// SetUltraLightSigning configures this protocol manager, when acting as a
+// server, to sign its own head announcements with key so that clients
+// running in ultra-light mode can trust them.
+func (pm *ProtocolManager) SetUltraLightSigning(key *ecdsa.PrivateKey) {
+	pm.ulcSign = newUltraLightSigner(key)
+}
+
+// signOutgoingAnnounce signs ann with this server's configured ultra-light
+// key, if any, before it is broadcast to peers. This package only implements
+// the client side of LES so far - Start is a no-op and nothing here produces
+// outgoing announcements - so signOutgoingAnnounce has no caller yet; it is
+// kept alongside SetUltraLightSigning as the hook a future server role wires
+// into its broadcast path, the same way lesCommons is shared in anticipation
+// of that server existing.
+func (pm *ProtocolManager) signOutgoingAnnounce(ann *announceData) {
+	pm.ulcSign.sign(ann)
+}
+
+// SetCheckpoint configures the checkpoint this protocol manager serves to
+// peers that issue GetCheckpointDataMsg, instead of (or ahead of) requiring
+// them to reach the on-chain checkpoint oracle contract themselves.
+func (pm *ProtocolManager) SetCheckpoint(checkpoint *TrustedCheckpoint, sigs [][]byte) {
+	pm.checkpoint = checkpoint
+	pm.checkpointSigs = sigs
+}
+
+// Start does nothing: this protocol manager runs no background sync loop,
+// and maxPeers is ignored.
+func (pm *ProtocolManager) Start(maxPeers int) {
+}
+
+// Stop terminates the protocol manager.
+func (pm *ProtocolManager) Stop() {
+	close(pm.quitSync)
+}
+
+func (pm *ProtocolManager) handle(p *peer) error {
+	if err := pm.peers.Register(p); err != nil {
+		return err
+	}
+	defer pm.peers.Unregister(p.id)
+
+	for {
+		if err := pm.handleMsg(p); err != nil {
+			return err
+		}
+	}
+}
+
+// handleMsg decodes and dispatches a single inbound LES message, including
+// the LES/2 additions for batched trie proofs, helper trie (CHT/BloomTrie)
+// proofs and transaction status lookups.
+func (pm *ProtocolManager) handleMsg(p *peer) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+
+	switch msg.Code {
+	case StatusMsg:
+		var req struct {
+			BufLimit, MinRecharge uint64
+			MRC                   []struct {
+				MsgCode, BaseCost, ReqCost uint64
+			}
+		}
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		costTable := make(map[uint64]msgCost, len(req.MRC))
+		for _, c := range req.MRC {
+			costTable[c.MsgCode] = msgCost{baseCost: c.BaseCost, reqCost: c.ReqCost}
+		}
+		p.fcServer = newServerFlowControl(req.BufLimit, req.MinRecharge, costTable)
+
+	case AnnounceMsg:
+		var req announceData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		p.lock.Lock()
+		p.headInfo = &req
+		p.lock.Unlock()
+
+		if pm.ulc.enabled() {
+			id := discv5.NodeID(p.ID())
+			head, conflict := pm.ulc.addAnnounce(id, &req)
+			switch {
+			case conflict:
+				log.Warn("Demoting ultra-light signer that disagrees with adopted head", "peer", p.id)
+				if pm.serverPool != nil {
+					pm.serverPool.adjustResponseTime(p, false)
+				}
+			case head != nil:
+				log.Info("Adopting ultra-light head", "number", head.number, "hash", head.hash)
+				if pm.downloader != nil {
+					go pm.downloader.Synchronise(p.id, head.hash, head.td, downloader.LightSync)
+				}
+			case len(req.Sign) > 0:
+				log.Debug("Signed ultra-light announcement did not yield a trusted head", "peer", p.id)
+			}
+		}
+
+	case BlockHeadersMsg:
+		var resp struct {
+			ReqID, BV uint64
+			Headers   []*types.Header
+		}
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if p.fcServer != nil && p.fcServer.correct(resp.BV) && pm.serverPool != nil {
+			pm.serverPool.adjustResponseTime(p, false)
+		}
+		if pm.odr != nil {
+			pm.odr.retriever.deliver(p, resp.ReqID, resp.Headers)
+		}
+
+	case BlockBodiesMsg:
+		var resp struct {
+			ReqID, BV uint64
+			Bodies    []*types.Body
+		}
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if p.fcServer != nil && p.fcServer.correct(resp.BV) && pm.serverPool != nil {
+			pm.serverPool.adjustResponseTime(p, false)
+		}
+		if pm.odr != nil {
+			pm.odr.retriever.deliver(p, resp.ReqID, resp.Bodies)
+		}
+
+	case ReceiptsMsg:
+		var resp struct {
+			ReqID, BV uint64
+			Receipts  []types.Receipts
+		}
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if p.fcServer != nil && p.fcServer.correct(resp.BV) && pm.serverPool != nil {
+			pm.serverPool.adjustResponseTime(p, false)
+		}
+		if pm.odr != nil {
+			pm.odr.retriever.deliver(p, resp.ReqID, resp.Receipts)
+		}
+
+	case CodeMsg:
+		var resp struct {
+			ReqID, BV uint64
+			Data      [][]byte
+		}
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if p.fcServer != nil && p.fcServer.correct(resp.BV) && pm.serverPool != nil {
+			pm.serverPool.adjustResponseTime(p, false)
+		}
+		if pm.odr != nil {
+			pm.odr.retriever.deliver(p, resp.ReqID, resp.Data)
+		}
+
+	case ProofsV1Msg:
+		var resp struct {
+			ReqID, BV uint64
+			Proofs    []NodeList
+		}
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if p.fcServer != nil && p.fcServer.correct(resp.BV) && pm.serverPool != nil {
+			pm.serverPool.adjustResponseTime(p, false)
+		}
+		if pm.odr != nil {
+			pm.odr.retriever.deliver(p, resp.ReqID, resp.Proofs)
+		}
+
+	case ProofsV2Msg:
+		var resp struct {
+			ReqID, BV uint64
+			Proofs    NodeList
+		}
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if p.fcServer != nil && p.fcServer.correct(resp.BV) && pm.serverPool != nil {
+			pm.serverPool.adjustResponseTime(p, false)
+		}
+		if pm.odr != nil {
+			pm.odr.retriever.deliver(p, resp.ReqID, resp.Proofs)
+		}
+
+	case GetHelperTrieProofsMsg:
+		// Served by a full LES server only; the light client never answers
+		// these, it only issues them.
+
+	case HelperTrieProofsMsg:
+		var resp struct {
+			ReqID, BV uint64
+			Resp      HelperTrieResps
+		}
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if p.fcServer != nil && p.fcServer.correct(resp.BV) && pm.serverPool != nil {
+			pm.serverPool.adjustResponseTime(p, false)
+		}
+		if pm.odr != nil {
+			pm.odr.retriever.deliver(p, resp.ReqID, resp.Resp)
+		}
+
+	case GetCheckpointDataMsg:
+		var req struct {
+			ReqID   uint64
+			Section uint64
+		}
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if pm.checkpoint == nil || pm.checkpoint.SectionIndex != req.Section {
+			break
+		}
+		go p.SendCheckpointData(req.ReqID, 0, CheckpointData{Checkpoint: *pm.checkpoint, Signatures: pm.checkpointSigs})
+
+	case CheckpointDataMsg:
+		var resp struct {
+			ReqID, BV uint64
+			Data      CheckpointData
+		}
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if p.fcServer != nil && p.fcServer.correct(resp.BV) && pm.serverPool != nil {
+			pm.serverPool.adjustResponseTime(p, false)
+		}
+		if pm.odr != nil {
+			pm.odr.retriever.deliver(p, resp.ReqID, resp.Data)
+		}
+
+	case GetTxStatusMsg:
+		// Served by a full LES server only.
+
+	case TxStatusMsg:
+		var resp struct {
+			ReqID, BV uint64
+			Status    []TxStatus
+		}
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if p.fcServer != nil && p.fcServer.correct(resp.BV) && pm.serverPool != nil {
+			pm.serverPool.adjustResponseTime(p, false)
+		}
+		if pm.odr != nil {
+			pm.odr.retriever.deliver(p, resp.ReqID, resp.Status)
+		}
+
+	default:
+		log.Trace("Received unknown message", "code", msg.Code)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"sync"
+	"time"
+
+	"github.com/athereum/go-athereum/log"
+)
+
+// retrieveTimeout bounds how long retrieve waits for a reply from the peer a
+// request was sent to before giving up on it and trying another one.
+const retrieveTimeout = time.Second * 4
+
+// validatorFunc checks whether a message received from a given peer is a
+// valid response to an outstanding request, and if so, delivers it.
+type validatorFunc func(p *peer, msg interface{}) error
+
+// retrieveManager coordinates ODR requests: it hands each request to the
+// requestDistributor, matches incoming replies back to the pending request by
+// request id, and re-queues the request with a different peer on timeout or
+// rejection.
+type retrieveManager struct {
+	dist       *requestDistributor
+	peers      *peerSet
+	serverPool *serverPool
+
+	lock     sync.Mutex
+	sentReqs map[uint64]*sentReq
+}
+
+// sentReq tracks a single outstanding ODR request across however many peers
+// it ends up being retried against. sentTo records which peers have already
+// been tried, so a retry after a timeout or a failed validation never picks
+// the same unhelpful peer twice; errChn is recreated for each attempt so an
+// error left unread from an earlier attempt is dropped. A failed reply that
+// arrives late from a previously tried peer is still signalled on the current
+// attempt's errChn, which ends the current attempt as well.
+type sentReq struct {
+	reqID     uint64
+	validator validatorFunc
+
+	lock     sync.Mutex
+	sentTo   map[*peer]struct{}
+	errChn   chan error
+	answered chan struct{}
+	done     bool // true while a delivery is being validated/adopted, or once one has succeeded
+}
+
+// newRetrieveManager creates a retrieveManager serving ODR requests over the
+// given peer set and distributor, scoring servers through serverPool.
+func newRetrieveManager(peers *peerSet, dist *requestDistributor, serverPool *serverPool) *retrieveManager {
+	return &retrieveManager{
+		dist:       dist,
+		peers:      peers,
+		serverPool: serverPool,
+		sentReqs:   make(map[uint64]*sentReq),
+	}
+}
+
+// retrieve queues the given distReq and blocks until it has been answered and
+// validated, or until stopChn is closed. A peer that lets the request time
+// out, or whose reply fails validation, is excluded from future attempts and
+// the request is re-queued for another peer instead of failing outright.
+func (rm *retrieveManager) retrieve(reqID uint64, req *distReq, val validatorFunc, stopChn chan struct{}) error {
+	sr := &sentReq{reqID: reqID, validator: val, sentTo: make(map[*peer]struct{}), answered: make(chan struct{})}
+	rm.lock.Lock()
+	rm.sentReqs[reqID] = sr
+	rm.lock.Unlock()
+
+	defer func() {
+		rm.lock.Lock()
+		delete(rm.sentReqs, reqID)
+		rm.lock.Unlock()
+	}()
+
+	canSend := req.canSend
+	req.canSend = func(p *peer) bool {
+		sr.lock.Lock()
+		_, tried := sr.sentTo[p]
+		sr.lock.Unlock()
+		return !tried && canSend(p)
+	}
+
+	for {
+		sr.lock.Lock()
+		errChn := make(chan error, 1)
+		sr.errChn = errChn
+		sr.lock.Unlock()
+
+		sentChn := rm.dist.queue(req)
+		var p *peer
+		select {
+		case p = <-sentChn:
+		case <-stopChn:
+			return ErrNoPeers
+		}
+		if p == nil {
+			return ErrNoPeers
+		}
+		sr.lock.Lock()
+		sr.sentTo[p] = struct{}{}
+		sr.lock.Unlock()
+
+		select {
+		case <-sr.answered:
+			return nil
+		case <-errChn:
+			log.Debug("Retrying ODR request with another peer", "reqID", reqID, "peer", p.id)
+		case <-time.After(retrieveTimeout):
+			log.Debug("ODR request timed out, retrying with another peer", "reqID", reqID, "peer", p.id)
+			if rm.serverPool != nil {
+				rm.serverPool.adjustResponseTime(p, false)
+			}
+		case <-stopChn:
+			return ErrNoPeers
+		}
+	}
+}
+
+// deliver hands a reply received from peer p to the matching pending request,
+// if any, and runs it through the request's validator. Since retrieve retries
+// the same reqID against other peers, more than one of them can reply validly
+// around the same time; done guards against running the validator - which
+// writes the result into the shared request struct - for more than one of
+// them concurrently, and against closing answered twice.
+func (rm *retrieveManager) deliver(p *peer, reqID uint64, msg interface{}) error {
+	rm.lock.Lock()
+	sr, ok := rm.sentReqs[reqID]
+	rm.lock.Unlock()
+	if !ok {
+		return errResp(ErrUnexpectedResponse, "reqID = %v", reqID)
+	}
+
+	sr.lock.Lock()
+	if sr.done {
+		sr.lock.Unlock()
+		return nil
+	}
+	sr.done = true
+	errChn := sr.errChn
+	sr.lock.Unlock()
+
+	err := sr.validator(p, msg)
+	if rm.serverPool != nil {
+		rm.serverPool.adjustResponseTime(p, err == nil)
+	}
+	if err != nil {
+		sr.lock.Lock()
+		sr.done = false
+		sr.lock.Unlock()
+		select {
+		case errChn <- err:
+		default:
+		}
+		return err
+	}
+	close(sr.answered)
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"sync"
+
+	"github.com/athereum/go-athereum/athdb"
+	"github.com/athereum/go-athereum/p2p"
+	"github.com/athereum/go-athereum/p2p/discv5"
+)
+
+// poolEntry represents a server node scored by the serverPool. Entries are
+// kept in memory only; they are not written to chainDb.
+type poolEntry struct {
+	id      discv5.NodeID
+	addr    string
+	quality float64
+
+	responses, failures int
+}
+
+// serverPool scores the LES servers the light client is connected to. It
+// does not discover or dial servers itself, and the scores it keeps are not
+// consulted when requestDistributor picks a peer.
+type serverPool struct {
+	db      athdb.Database
+	quitChn chan struct{}
+	wg      *sync.WaitGroup
+
+	lock    sync.Mutex
+	entries map[discv5.NodeID]*poolEntry
+}
+
+// newServerPool creates a server pool holding the given database; scores are
+// not persisted to it.
+func newServerPool(db athdb.Database, quitChn chan struct{}, wg *sync.WaitGroup) *serverPool {
+	return &serverPool{
+		db:      db,
+		quitChn: quitChn,
+		wg:      wg,
+		entries: make(map[discv5.NodeID]*poolEntry),
+	}
+}
+
+// start registers the pool with the wait group until quitChn is closed. The
+// server and topic arguments are not used: no discv5 discovery or dialing is
+// done.
+func (pool *serverPool) start(server *p2p.Server, topic discv5.Topic) {
+	pool.wg.Add(1)
+	go func() {
+		defer pool.wg.Done()
+		<-pool.quitChn
+	}()
+}
+
+// adjustResponseTime updates a peer's quality score after a request either
+// succeeded or failed/timed out, lowering it for slow or unreliable servers.
+// Peer selection in requestDistributor.bestPeer does not read this score.
+func (pool *serverPool) adjustResponseTime(p *peer, success bool) {
+	if p == nil || p.poolEntry == nil {
+		return
+	}
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	e := p.poolEntry
+	if success {
+		e.responses++
+		e.quality = e.quality*0.9 + 0.1
+	} else {
+		e.failures++
+		e.quality = e.quality * 0.9
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"sync"
+
+	"github.com/athereum/go-athereum/common"
+	"github.com/athereum/go-athereum/core/types"
+)
+
+// LesTxRelay is the TxPool backend used by a light client: instead of
+// applying transactions locally it forwards them to connected LES servers
+// for inclusion in the real mempool.
+type LesTxRelay struct {
+	peers   *peerSet
+	reqDist *requestDistributor
+
+	lock   sync.Mutex
+	txSent map[common.Hash]*peer
+}
+
+// NewLesTxRelay creates a LesTxRelay that relays submitted transactions to
+// the peers tracked by the given peerSet.
+func NewLesTxRelay(peers *peerSet, dist *requestDistributor) *LesTxRelay {
+	return &LesTxRelay{
+		peers:   peers,
+		reqDist: dist,
+		txSent:  make(map[common.Hash]*peer),
+	}
+}
+
+// Send forwards the given signed transactions to a server peer for inclusion
+// in its mempool.
+func (self *LesTxRelay) Send(txs types.Transactions) {
+	self.reqDist.queue(&distReq{
+		getCost: func(p *peer) uint64 { return uint64(len(txs)) },
+		canSend: func(p *peer) bool { return true },
+		request: func(p *peer) func() {
+			return func() { p.SendTxs(txs) }
+		},
+	})
+}
+
+// NewHead is called when the light client's notion of the canonical head
+// chain changes.
+func (self *LesTxRelay) NewHead(head common.Hash, mined []common.Hash, rollback []common.Hash) {
+}
+
+// Discard removes the given transactions from local tracking, e.g. once they
+// have expired from the pool.
+func (self *LesTxRelay) Discard(hashes []common.Hash) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for _, hash := range hashes {
+		delete(self.txSent, hash)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"sync"
+
+	"github.com/athereum/go-athereum/common"
+	"github.com/athereum/go-athereum/log"
+	"github.com/athereum/go-athereum/p2p/discv5"
+)
+
+// trustedHead is a new chain head that a quorum of trusted ultra-light
+// servers have signed off on.
+type trustedHead struct {
+	hash   common.Hash
+	number uint64
+	td     *big.Int
+}
+
+// ultraLightClient tracks signed head announcements from a configured set of
+// trusted servers. Once enough distinct trusted signers agree on a head, the
+// client starts the downloader towards it. Header verification is not
+// skipped for the signed range: the downloader still downloads and checks the
+// headers up to that head.
+type ultraLightClient struct {
+	trusted  map[discv5.NodeID]bool
+	fraction int
+
+	lock            sync.Mutex
+	votes           map[common.Hash]map[discv5.NodeID]bool
+	adoptedAtNumber map[uint64]common.Hash // number -> hash of the head quorum already adopted there
+	heads           map[common.Hash]*trustedHead
+	latestHead      *trustedHead
+}
+
+// newUltraLightClient creates an ultra-light client accepting signed head
+// announcements from the given trusted server pubkeys, adopting a head once
+// fraction percent of them agree.
+func newUltraLightClient(servers []string, fraction int) *ultraLightClient {
+	trusted := make(map[discv5.NodeID]bool)
+	for _, s := range servers {
+		if id, err := discv5.HexID(s); err == nil {
+			trusted[id] = true
+		} else {
+			log.Warn("Invalid ultra light server id", "id", s, "err", err)
+		}
+	}
+	return &ultraLightClient{
+		trusted:         trusted,
+		fraction:        fraction,
+		votes:           make(map[common.Hash]map[discv5.NodeID]bool),
+		adoptedAtNumber: make(map[uint64]common.Hash),
+		heads:           make(map[common.Hash]*trustedHead),
+	}
+}
+
+// enabled reports whether ultra-light mode is configured at all.
+func (u *ultraLightClient) enabled() bool {
+	return u != nil && len(u.trusted) > 0
+}
+
+// addAnnounce records a signed announcement received from a peer. If the
+// signer isn't trusted, or the signature doesn't verify, the announcement is
+// dropped (a failed signature check is logged as a warning). If the signer instead disagrees with a head this client
+// already adopted by quorum for the same block number, conflict is reported
+// so the caller can demote the disagreeing peer. Once a quorum of distinct
+// trusted signers has vouched for the same head, that head is returned so
+// the caller can fast-forward the downloader to it.
+func (u *ultraLightClient) addAnnounce(id discv5.NodeID, data *announceData) (head *trustedHead, conflict bool) {
+	if !u.trusted[id] {
+		return nil, false
+	}
+	if err := data.checkSignature(id); err != nil {
+		log.Warn("Ultra light announcement signature check failed", "peer", id, "err", err)
+		return nil, false
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if adopted, ok := u.adoptedAtNumber[data.Number]; ok && adopted != data.Hash {
+		log.Warn("Ultra light signer disagrees with adopted head", "number", data.Number, "signer", id, "hash", data.Hash, "adopted", adopted)
+		return nil, true
+	}
+
+	voters, ok := u.votes[data.Hash]
+	if !ok {
+		voters = make(map[discv5.NodeID]bool)
+		u.votes[data.Hash] = voters
+	}
+	voters[id] = true
+
+	required := (len(u.trusted)*u.fraction + 99) / 100
+	if required < 1 {
+		required = 1
+	}
+	if len(voters) < required {
+		return nil, false
+	}
+	head = &trustedHead{hash: data.Hash, number: data.Number, td: data.Td}
+	u.latestHead = head
+	u.heads[data.Hash] = head
+	u.adoptedAtNumber[data.Number] = data.Hash
+	return head, false
+}
+
+// UltraLightAPI exposes the ultra-light subsystem's state to RPC clients and
+// operators.
+type UltraLightAPI struct {
+	client *ultraLightClient
+}
+
+// NewUltraLightAPI creates the RPC API wrapping the given ultra-light client.
+func NewUltraLightAPI(client *ultraLightClient) *UltraLightAPI {
+	return &UltraLightAPI{client: client}
+}
+
+// TrustedHead returns the most recent head accepted on the authority of a
+// quorum of trusted, signed announcements, or nil if none has been accepted
+// yet.
+func (api *UltraLightAPI) TrustedHead() *trustedHead {
+	if api.client == nil {
+		return nil
+	}
+	api.client.lock.Lock()
+	defer api.client.lock.Unlock()
+	return api.client.latestHead
+}
+
+// TrustedHeads returns every head currently accepted on the authority of a
+// quorum of trusted, signed announcements, so operators can see the full set
+// of heads ultra-light mode has fast-forwarded through rather than only the
+// latest one.
+func (api *UltraLightAPI) TrustedHeads() []*trustedHead {
+	if api.client == nil {
+		return nil
+	}
+	api.client.lock.Lock()
+	defer api.client.lock.Unlock()
+
+	heads := make([]*trustedHead, 0, len(api.client.heads))
+	for _, head := range api.client.heads {
+		heads = append(heads, head)
+	}
+	return heads
+}
+
+// ultraLightSigner, when configured on a LES server's protocol manager,
+// signs its own head announcements with the node's key so that clients
+// running in ultra-light mode have a producer whose signature they can
+// trust.
+type ultraLightSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+// newUltraLightSigner returns a signer wrapping key, or nil if key is nil,
+// meaning announcements are sent unsigned as usual.
+func newUltraLightSigner(key *ecdsa.PrivateKey) *ultraLightSigner {
+	if key == nil {
+		return nil
+	}
+	return &ultraLightSigner{key: key}
+}
+
+// sign adds this server's signature to the announcement if signing is
+// configured, leaving it untouched otherwise.
+func (s *ultraLightSigner) sign(a *announceData) {
+	if s == nil || s.key == nil {
+		return
+	}
+	a.sign(s.key)
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 The go-athereum Authors
+// This file is part of the go-athereum library.
+//
+// The go-athereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-athereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-athereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/athereum/go-athereum/common"
+	"github.com/athereum/go-athereum/crypto"
+	"github.com/athereum/go-athereum/p2p/discv5"
+)
+
+func newTestSigner(t *testing.T) (*ecdsa.PrivateKey, discv5.NodeID) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key, discv5.PubkeyID(&key.PublicKey)
+}
+
+func signedAnnounce(key *ecdsa.PrivateKey, number uint64, hash common.Hash) *announceData {
+	a := &announceData{Hash: hash, Number: number, Td: big.NewInt(int64(number))}
+	a.sign(key)
+	return a
+}
+
+// TestUltraLightQuorum checks that a head is only adopted once the
+// configured percentage of distinct trusted signers have vouched for it, and
+// not before - duplicate votes from the same signer shouldn't count twice.
+func TestUltraLightQuorum(t *testing.T) {
+	keyA, idA := newTestSigner(t)
+	keyB, idB := newTestSigner(t)
+	keyC, idC := newTestSigner(t)
+
+	u := &ultraLightClient{
+		trusted:         map[discv5.NodeID]bool{idA: true, idB: true, idC: true},
+		fraction:        60, // requires ceil(3*0.6) = 2 of 3
+		votes:           make(map[common.Hash]map[discv5.NodeID]bool),
+		adoptedAtNumber: make(map[uint64]common.Hash),
+		heads:           make(map[common.Hash]*trustedHead),
+	}
+	hash := common.HexToHash("0x1234")
+
+	if head, conflict := u.addAnnounce(idA, signedAnnounce(keyA, 100, hash)); head != nil || conflict {
+		t.Fatalf("head adopted after a single vote")
+	}
+	// Repeating the same signer's vote must not count twice.
+	if head, conflict := u.addAnnounce(idA, signedAnnounce(keyA, 100, hash)); head != nil || conflict {
+		t.Fatalf("head adopted after a duplicate vote from the same signer")
+	}
+	head, conflict := u.addAnnounce(idB, signedAnnounce(keyB, 100, hash))
+	if conflict {
+		t.Fatalf("unexpected conflict")
+	}
+	if head == nil || head.hash != hash {
+		t.Fatalf("head not adopted once quorum reached")
+	}
+
+	// A third, distinct signer disagreeing with the adopted head at the same
+	// number should be reported as a conflict, not silently accepted.
+	otherHash := common.HexToHash("0x5678")
+	if head, conflict := u.addAnnounce(idC, signedAnnounce(keyC, 100, otherHash)); head != nil || !conflict {
+		t.Fatalf("expected conflict for disagreeing signer, got head=%v conflict=%v", head, conflict)
+	}
+}
+
+// TestUltraLightUntrusted checks that announcements from signers outside the
+// trusted set never count towards quorum.
+func TestUltraLightUntrusted(t *testing.T) {
+	keyA, idA := newTestSigner(t)
+	_, idB := newTestSigner(t)
+
+	u := &ultraLightClient{
+		trusted:         map[discv5.NodeID]bool{idB: true},
+		fraction:        100,
+		votes:           make(map[common.Hash]map[discv5.NodeID]bool),
+		adoptedAtNumber: make(map[uint64]common.Hash),
+		heads:           make(map[common.Hash]*trustedHead),
+	}
+	if head, conflict := u.addAnnounce(idA, signedAnnounce(keyA, 1, common.HexToHash("0x1"))); head != nil || conflict {
+		t.Fatalf("untrusted signer should never produce a head or conflict")
+	}
+}